@@ -0,0 +1,251 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/go-sql-driver/mysql"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/therealpenguin/takeabow-upload-processor/logging"
+	"github.com/therealpenguin/takeabow-upload-processor/storage"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// DefaultAdminAddr is where the admin server listens unless told otherwise
+const DefaultAdminAddr = ":8080"
+
+// HealthTimeout is how long each dependency gets to answer a health check
+const HealthTimeout = 2 * time.Second
+
+// HealthKey is the storage key that is looked up to check storage is reachable. It doesn't need to exist
+const HealthKey = "healthz"
+
+// Redacted replaces secrets in the config. It's what url.URL.Redacted puts in place of a password, so they all look the same
+const Redacted = "xxxxx"
+
+// serveAdmin runs the admin HTTP server until ctx is cancelled
+func (a *App) serveAdmin(ctx context.Context) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", a.healthz)
+	mux.HandleFunc("/readyz", a.readyz)
+	mux.HandleFunc("/jobs", a.jobs)
+	mux.HandleFunc("/config", a.config)
+	mux.Handle("/metrics", promhttp.Handler())
+
+	server := &http.Server{
+		Addr:              a.AdminAddr,
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+
+		shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdown)
+	}()
+
+	slog.Info("Admin server listening", "addr", a.AdminAddr)
+	err := server.ListenAndServe()
+	if err != nil && err != http.ErrServerClosed {
+		slog.Error("Admin server stopped", logging.Err(err))
+	}
+}
+
+// healthz checks every dependency is reachable, answering 503 if any isn't. The checks run at once, each for up to HealthTimeout
+func (a *App) healthz(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), HealthTimeout)
+	defer cancel()
+
+	checks := map[string]func(context.Context) error{
+		"amqp":    func(context.Context) error { return a.checkAMQP() },
+		"mysql":   a.DB.PingContext,
+		"redis":   func(context.Context) error { return a.Redis.Ping().Err() },
+		"storage": a.checkStorage,
+	}
+
+	results := make(map[string]error, len(checks))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for name, check := range checks {
+		wg.Add(1)
+		go func(name string, check func(context.Context) error) {
+			defer wg.Done()
+			err := within(ctx, check)
+
+			mu.Lock()
+			results[name] = err
+			mu.Unlock()
+		}(name, check)
+	}
+	wg.Wait()
+
+	status := http.StatusOK
+	body := make(map[string]string)
+	for name, err := range results {
+		body[name] = "ok"
+		if err != nil {
+			body[name] = err.Error()
+			status = http.StatusServiceUnavailable
+		}
+	}
+
+	writeJSON(w, status, body)
+}
+
+// within runs check, giving up once ctx is done even if check doesn't, as the Redis client can't be cancelled
+func within(ctx context.Context, check func(context.Context) error) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- check(ctx)
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// checkAMQP checks the app is connected to RabbitMQ
+func (a *App) checkAMQP() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.conn == nil || a.conn.IsClosed() {
+		return errors.New("not connected")
+	}
+
+	return nil
+}
+
+// checkStorage checks storage answers. A key that doesn't exist is an answer
+func (a *App) checkStorage(ctx context.Context) error {
+	_, err := a.Storage.Head(ctx, HealthKey)
+	if err == storage.ErrNotExist {
+		return nil
+	}
+
+	return err
+}
+
+// readyz answers 200 if any worker is idle and able to take a video, and 503 if they are all busy or stopping
+func (a *App) readyz(w http.ResponseWriter, r *http.Request) {
+	a.mu.Lock()
+	workers := a.workers
+	a.mu.Unlock()
+
+	idle := 0
+	for _, worker := range workers {
+		if !worker.stopped() && worker.current() == nil {
+			idle++
+		}
+	}
+
+	status := http.StatusOK
+	if idle == 0 || a.checkAMQP() != nil {
+		status = http.StatusServiceUnavailable
+	}
+
+	writeJSON(w, status, map[string]int{"workers": len(workers), "idle": idle})
+}
+
+// jobs lists the videos being processed
+func (a *App) jobs(w http.ResponseWriter, r *http.Request) {
+	a.mu.Lock()
+	workers := a.workers
+	a.mu.Unlock()
+
+	jobs := make([]*Job, 0)
+	for _, worker := range workers {
+		if job := worker.current(); job != nil {
+			jobs = append(jobs, job)
+		}
+	}
+
+	writeJSON(w, http.StatusOK, jobs)
+}
+
+// config shows the config the app is running with, without its secrets
+func (a *App) config(w http.ResponseWriter, r *http.Request) {
+	redisPassword := ""
+	if a.RedisConfig.Password != "" {
+		redisPassword = Redacted
+	}
+
+	timelines := a.Timelines.Load()
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"amqp_url":          redactURL(a.AMQPUrl),
+		"mysql_dsn":         redactDSN(a.MySQLDSN),
+		"redis_addr":        a.RedisConfig.Addr,
+		"redis_password":    redisPassword,
+		"redis_db":          a.RedisConfig.DB,
+		"redis_tls":         a.RedisConfig.TLS,
+		"redis_namespace":   a.RedisConfig.Namespace,
+		"storage":           fmt.Sprint(a.Storage),
+		"bucket":            a.Bucket,
+		"region":            a.Region,
+		"tmp_dir":           a.TmpDir,
+		"split_prefix":      a.SplitPrefix,
+		"thumbnails":        a.Thumbnails,
+		"profiles":          a.Profiles,
+		"rules":             a.Rules,
+		"timelines":         timelines.Names(),
+		"default_timeline":  timelines.Default,
+		"timelines_version": timelines.Version,
+		"progress_amqp":     a.ProgressAMQP,
+		"webhooks":          a.WebhookSecret != "",
+		"workers":           a.Workers,
+		"prefetch":          a.Prefetch,
+		"max_attempts":      a.MaxAttempts,
+		"retry_delay":       a.RetryDelay.String(),
+		"shutdown_grace":    a.ShutdownGrace.String(),
+		"admin_addr":        a.AdminAddr,
+	})
+}
+
+// redactURL hides the password in a URL, or the whole URL if it can't be parsed
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return Redacted
+	}
+
+	return u.Redacted()
+}
+
+// redactDSN hides the password in a MySQL DSN, or the whole DSN if it can't be parsed
+func redactDSN(dsn string) string {
+	if dsn == "" {
+		return ""
+	}
+
+	c, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		return Redacted
+	}
+
+	if c.Passwd != "" {
+		c.Passwd = Redacted
+	}
+
+	return c.FormatDSN()
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		slog.Warn("Error writing admin response", logging.Err(err))
+	}
+}
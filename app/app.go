@@ -1,49 +1,124 @@
 package app
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/credentials"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/streadway/amqp"
+	"github.com/therealpenguin/takeabow-upload-processor/command"
+	"github.com/therealpenguin/takeabow-upload-processor/events"
+	"github.com/therealpenguin/takeabow-upload-processor/logging"
 	"github.com/therealpenguin/takeabow-upload-processor/processor"
+	"github.com/therealpenguin/takeabow-upload-processor/profile"
+	"github.com/therealpenguin/takeabow-upload-processor/segment"
+	"github.com/therealpenguin/takeabow-upload-processor/slots"
+	"github.com/therealpenguin/takeabow-upload-processor/steps"
+	"github.com/therealpenguin/takeabow-upload-processor/storage"
 	"github.com/therealpenguin/takeabow-upload-processor/timecode"
+	"github.com/therealpenguin/takeabow-upload-processor/validate"
 	"github.com/therealpenguin/takeabow-upload-processor/video"
+	"github.com/therealpenguin/takeabow-upload-processor/webhook"
 	"gopkg.in/redis.v5"
-	"log"
+	"log/slog"
 	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 const EnvBucket = "BOW_BUCKET"
 const EnvProcessedPrefix = "BOW_PREFIX_PROCESSED"
 const EnvSmallPrefix = "BOW_PREFIX_SMALL"
 const EnvSplitPrefix = "BOW_PREFIX_SPLIT"
+const EnvThumbsPrefix = "BOW_PREFIX_THUMBS"
+const EnvThumbsCount = "BOW_THUMBS_COUNT"
+const EnvThumbsFormat = "BOW_THUMBS_FORMAT"
+const EnvThumbsWidth = "BOW_THUMBS_WIDTH"
 const EnvAMQPUrl = "BOW_AMQP"
 const EnvMYSQLDsn = "BOW_MYSQL_DSN"
 const EnvTmpDir = "BOW_TMP_DIR"
 const EnvRedisAddr = "BOW_REDIS_ADDR"
+const EnvRedisPassword = "BOW_REDIS_PASSWORD"
+const EnvRedisDB = "BOW_REDIS_DB"
+const EnvRedisTLS = "BOW_REDIS_TLS"
+const EnvRedisNamespace = "BOW_REDIS_NAMESPACE"
+const EnvAdminAddr = "BOW_ADMIN_ADDR"
+const EnvLogFormat = "BOW_LOG_FORMAT"
+const EnvLogLevel = "BOW_LOG_LEVEL"
+const EnvTraceExporter = "BOW_TRACE_EXPORTER"
+const EnvOTLPEndpoint = "BOW_OTLP_ENDPOINT"
+const EnvWorkers = "BOW_WORKERS"
+const EnvPrefetch = "BOW_PREFETCH"
+const EnvMaxAttempts = "BOW_MAX_ATTEMPTS"
+const EnvRetryDelay = "BOW_RETRY_DELAY"
+const EnvShutdownGrace = "BOW_SHUTDOWN_GRACE"
+const EnvStorage = "BOW_STORAGE"
+const EnvStorageDir = "BOW_STORAGE_DIR"
+const EnvRegion = "BOW_AWS_REGION"
+const EnvProfiles = "BOW_PROFILES"
+const EnvFFmpeg = "BOW_FFMPEG"
+const EnvFFprobe = "BOW_FFPROBE"
+const EnvYoutubeDL = "BOW_YOUTUBE_DL"
+const EnvCommandTimeout = "BOW_COMMAND_TIMEOUT"
+const EnvProgressAMQP = "BOW_PROGRESS_AMQP"
+const EnvWebhookSecret = "BOW_WEBHOOK_SECRET"
+const EnvSplitStrategy = "BOW_SPLIT_STRATEGY"
+const EnvTimecodes = "BOW_TIMECODES"
+const EnvTimelines = "BOW_TIMELINES"
+const EnvDefaultTimeline = "BOW_DEFAULT_TIMELINE"
 
 const ChannelUploads = "uploads"
 
+// QueueReprocess is where the reprocess command publishes videos. Only its deliveries skip the check for duplicates,
+// so who can reprocess is down to who can publish to it
+const QueueReprocess = "uploads.reprocess"
+
 const TemplateEmpty = "%s is empty"
+const TemplatePositive = "%s must be a positive integer"
+const TemplateNonNegative = "%s must be 0 or a positive integer"
 
 // App holds a valid configuration and some dependencies for the upload processor
 type App struct {
-	Ch              *amqp.Channel
-	S3              *s3.S3
-	Sess            *session.Session
+	AMQPUrl         string
+	Storage         storage.Backend
+	StorageKind     string
+	StorageDir      string
+	Region          string
 	DB              *sql.DB
+	MySQLDSN        string
 	Bucket          string
 	ProcessedPrefix string
 	TmpDir          string
-	processor       *processor.Processor
 	SmallPrefix     string
-	Timecodes       *[]timecode.Timecode
+	Timelines       *timecode.Store
+	TimelinesPath   string
+	DefaultTimeline string
 	SplitPrefix     string
+	Thumbnails      processor.Thumbnails
 	Redis           *redis.Client
+	RedisConfig     slots.Config
+	Slots           *slots.Registry
+	Steps           *steps.Tracker
+	Events          *events.Publisher
+	Webhooks        *webhook.Outbox
+	WebhookSecret   string
+	Profiles        []profile.Profile
+	Rules           validate.Rules
+	ProgressAMQP    bool
+	Strategy        segment.Strategy
+	Workers         int
+	Prefetch        int
+	MaxAttempts     int
+	RetryDelay      time.Duration
+	ShutdownGrace   time.Duration
+	AdminAddr       string
+	workers         []*worker
+	conn            *amqp.Connection
+	dbUp            atomic.Bool
+	mu              sync.Mutex
 }
 
 // NewVideoRequest creates and validates the application's config
@@ -54,32 +129,84 @@ func New() (*App, error) {
 		TmpDir:          os.Getenv(EnvTmpDir),
 		SmallPrefix:     os.Getenv(EnvSmallPrefix),
 		SplitPrefix:     os.Getenv(EnvSplitPrefix),
+		AMQPUrl:         os.Getenv(EnvAMQPUrl),
+		MySQLDSN:        os.Getenv(EnvMYSQLDsn),
+		AdminAddr:       os.Getenv(EnvAdminAddr),
+		StorageKind:     os.Getenv(EnvStorage),
+		StorageDir:      os.Getenv(EnvStorageDir),
+		Region:          os.Getenv(EnvRegion),
+		ProgressAMQP:    os.Getenv(EnvProgressAMQP) == "true",
+		WebhookSecret:   os.Getenv(EnvWebhookSecret),
 	}
 
-	if a.Bucket == "" {
-		return nil, errors.New(fmt.Sprintf(TemplateEmpty, EnvBucket))
+	if a.AdminAddr == "" {
+		a.AdminAddr = DefaultAdminAddr
 	}
 
-	if a.ProcessedPrefix == "" {
-		return nil, errors.New(fmt.Sprintf(TemplateEmpty, EnvProcessedPrefix))
+	if a.AMQPUrl == "" {
+		return nil, errors.New(fmt.Sprintf(TemplateEmpty, EnvAMQPUrl))
 	}
 
 	if a.TmpDir == "" {
 		return nil, errors.New(fmt.Sprintf(TemplateEmpty, EnvTmpDir))
 	}
 
-	if a.SmallPrefix == "" {
-		return nil, errors.New(fmt.Sprintf(TemplateEmpty, EnvSmallPrefix))
-	}
-
 	if a.SplitPrefix == "" {
 		return nil, errors.New(fmt.Sprintf(TemplateEmpty, EnvSplitPrefix))
 	}
 
+	thumbs, err := thumbnailsFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	a.Thumbnails = thumbs
+
+	workers, err := positiveIntFromEnv(EnvWorkers, 1)
+	if err != nil {
+		return nil, err
+	}
+	a.Workers = workers
+
+	prefetch, err := positiveIntFromEnv(EnvPrefetch, 1)
+	if err != nil {
+		return nil, err
+	}
+	a.Prefetch = prefetch
+
+	maxAttempts, err := positiveIntFromEnv(EnvMaxAttempts, 5)
+	if err != nil {
+		return nil, err
+	}
+	a.MaxAttempts = maxAttempts
+
+	retryDelay, err := positiveIntFromEnv(EnvRetryDelay, 30)
+	if err != nil {
+		return nil, err
+	}
+	a.RetryDelay = time.Duration(retryDelay) * time.Second
+
+	grace, err := positiveIntFromEnv(EnvShutdownGrace, 30)
+	if err != nil {
+		return nil, err
+	}
+	a.ShutdownGrace = time.Duration(grace) * time.Second
+
+	timeout, err := positiveIntFromEnv(EnvCommandTimeout, int(command.DefaultConfig.Timeout/time.Second))
+	if err != nil {
+		return nil, err
+	}
+
+	command.Configure(command.Config{
+		FFmpeg:    os.Getenv(EnvFFmpeg),
+		FFprobe:   os.Getenv(EnvFFprobe),
+		YoutubeDL: os.Getenv(EnvYoutubeDL),
+		Timeout:   time.Duration(timeout) * time.Second,
+	})
+
 	// Make the temp directory if it doesn't exist
-	_, err := os.Stat(a.TmpDir)
+	_, err = os.Stat(a.TmpDir)
 	if err != nil {
-		if err != os.ErrNotExist {
+		if !os.IsNotExist(err) {
 			return nil, err
 		}
 
@@ -90,125 +217,255 @@ func New() (*App, error) {
 		}
 	}
 
-	creds := credentials.NewEnvCredentials()
-	_, err = creds.Get()
+	store, err := a.newStorage()
 	if err != nil {
 		return nil, err
 	}
+	a.Storage = store
 
-	session, err := session.NewSession(aws.NewConfig().WithRegion("eu-west-1").WithCredentials(creds))
+	a.RedisConfig, err = redisFromEnv()
 	if err != nil {
 		return nil, err
 	}
-	a.Sess = session
-	a.S3 = s3.New(session)
 
-	redisAddr := os.Getenv(EnvRedisAddr)
-	if redisAddr == "" {
-		return nil, errors.New(fmt.Sprintf(TemplateEmpty, EnvRedisAddr))
+	a.Redis, err = slots.Dial(a.RedisConfig)
+	if err != nil {
+		return nil, err
 	}
+	a.Slots = slots.New(a.Redis, a.RedisConfig.Namespace)
+	a.Steps = steps.New(a.Redis, a.RedisConfig.Namespace)
+	a.Events = events.NewPublisher(a.connection)
 
-	redisClient := redis.NewClient(&redis.Options{
-		Addr:     redisAddr,
-		Password: "", // no password set
-		DB:       0,  // use default DB
-	})
-
-	_, err = redisClient.Ping().Result()
-
+	a.TimelinesPath, a.DefaultTimeline, err = timelinesFromEnv()
 	if err != nil {
 		return nil, err
 	}
 
-	a.Redis = redisClient
-
-	cwd, err := os.Getwd()
+	timelines, err := a.loadTimelines()
 	if err != nil {
 		return nil, err
 	}
+	a.Timelines = timecode.NewStore(timelines)
 
-	f, err := os.Open(fmt.Sprintf("%s/timecodes.csv", cwd))
+	a.Strategy, err = segment.New(os.Getenv(EnvSplitStrategy))
 	if err != nil {
 		return nil, err
 	}
 
-	defer f.Close()
-
-	timecodes, err := timecode.NewFromFile(f)
+	a.Rules, err = rulesFromEnv()
 	if err != nil {
 		return nil, err
 	}
-	a.Timecodes = timecodes
 
-	a.processor = processor.New(a.Sess, a.TmpDir, a.Bucket, a.ProcessedPrefix, a.SmallPrefix, a.SplitPrefix, a.Redis, timecodes)
+	a.Profiles, err = a.profilesFromEnv()
+	if err != nil {
+		return nil, err
+	}
 
 	return a, nil
 }
 
-func (a *App) Run() error {
-	msgs, err := a.Ch.Consume(
-		ChannelUploads, // queue
-		"",             // consumer
-		false,          // auto-ack
-		false,          // exclusive
-		false,          // no-local
-		false,          // no-wait
-		nil,            // args
-	)
+// profilesFromEnv gets the profiles from the config file if there is one, otherwise the processed and small videos we always have.
+// The prefixes of those are only needed when there's no config file
+func (a *App) profilesFromEnv() ([]profile.Profile, error) {
+	if path := os.Getenv(EnvProfiles); path != "" {
+		return profile.LoadFile(path)
+	}
 
-	if err != nil {
-		return err
+	if a.ProcessedPrefix == "" {
+		return nil, errors.New(fmt.Sprintf(TemplateEmpty, EnvProcessedPrefix))
 	}
 
-	log.Printf("Listening on channel %s", ChannelUploads)
+	if a.SmallPrefix == "" {
+		return nil, errors.New(fmt.Sprintf(TemplateEmpty, EnvSmallPrefix))
+	}
 
-	for d := range msgs {
-		v, err := video.New(d.Body, a.S3, a.Sess, a.Bucket)
-		if err != nil {
-			a.logOnError(nil, err)
-			d.Ack(false)
-			continue
-		}
+	return profile.Default(a.ProcessedPrefix, a.SmallPrefix), nil
+}
 
-		r := v.GetRequest()
-		r.SetOriginalUrl(a.DB)
-		fmt.Printf("Processing %s video\n%+v\n", r.GetSource(), r)
-		err = a.processor.Process(v)
-		if err != nil {
-			a.logOnError(v, err)
-			d.Ack(false)
-			continue
-		}
+// redisFromEnv gets how to connect to Redis. The database number defaults to 0, and the namespace to slots.DefaultNamespace
+func redisFromEnv() (slots.Config, error) {
+	c := slots.Config{
+		Addr:      os.Getenv(EnvRedisAddr),
+		Password:  os.Getenv(EnvRedisPassword),
+		TLS:       os.Getenv(EnvRedisTLS) == "true",
+		Namespace: os.Getenv(EnvRedisNamespace),
+	}
 
-		err = r.SetStatus("transcoded", a.DB)
-		if err != nil {
-			a.logOnError(v, err)
+	if c.Addr == "" {
+		return c, errors.New(fmt.Sprintf(TemplateEmpty, EnvRedisAddr))
+	}
+
+	if c.Namespace == "" {
+		c.Namespace = slots.DefaultNamespace
+	}
+
+	if db := os.Getenv(EnvRedisDB); db != "" {
+		i, err := strconv.Atoi(db)
+		if err != nil || i < 0 {
+			return c, errors.New(fmt.Sprintf("%s must be a database number", EnvRedisDB))
 		}
+		c.DB = i
+	}
+
+	return c, nil
+}
+
+// timelinesFromEnv gets where the timelines are and which is the default. That is every timeline in the directory
+// we're told to or, without one, the single timeline in the file we're told to or the timecodes.csv in the working directory
+func timelinesFromEnv() (string, string, error) {
+	def := os.Getenv(EnvDefaultTimeline)
+	if def == "" {
+		def = timecode.DefaultName
+	}
+
+	if dir := os.Getenv(EnvTimelines); dir != "" {
+		return dir, def, nil
+	}
 
-		err = r.SaveDuration(a.DB)
+	path := os.Getenv(EnvTimecodes)
+	if path == "" {
+		cwd, err := os.Getwd()
 		if err != nil {
-			a.logOnError(v, err)
+			return "", "", err
 		}
 
-		d.Ack(false)
-		fmt.Printf("Done processing %s video\n%+v\n", r.GetSource(), r)
+		path = filepath.Join(cwd, "timecodes.csv")
+	}
+
+	return path, def, nil
+}
+
+// loadTimelines reads the timelines from TimelinesPath. A single timeline file is the default whatever it's called
+func (a *App) loadTimelines() (*timecode.Timelines, error) {
+	if os.Getenv(EnvTimelines) != "" {
+		return timecode.LoadDir(a.TimelinesPath, a.DefaultTimeline)
+	}
+
+	timeline, err := timecode.LoadFile(a.TimelinesPath)
+	if err != nil {
+		return nil, err
+	}
+	timeline.Name = a.DefaultTimeline
+
+	return timecode.Single(timeline), nil
+}
+
+// thumbnailsFromEnv gets which stills to take of each video. Without a prefix none are taken
+func thumbnailsFromEnv() (processor.Thumbnails, error) {
+	t := processor.Thumbnails{
+		Prefix: os.Getenv(EnvThumbsPrefix),
+		Format: os.Getenv(EnvThumbsFormat),
+	}
+
+	if t.Format == "" {
+		t.Format = processor.FormatJPEG
+	}
+
+	// A count of 0 takes just the poster
+	count, err := nonNegativeIntFromEnv(EnvThumbsCount, 9)
+	if err != nil {
+		return t, err
+	}
+	t.Count = count
+
+	width, err := positiveIntFromEnv(EnvThumbsWidth, 640)
+	if err != nil {
+		return t, err
+	}
+	t.Width = width
+
+	return t, t.Validate()
+}
+
+// watchTimelines reloads the timelines whenever their files change, until ctx is cancelled
+func (a *App) watchTimelines(ctx context.Context) {
+	err := a.Timelines.Watch(ctx, a.TimelinesPath, a.loadTimelines)
+	if err != nil {
+		slog.Error("Couldn't watch timelines, they won't be reloaded", "path", a.TimelinesPath, logging.Err(err))
+	}
+}
+
+// deliverWebhooks sends the callbacks in the outbox until ctx is cancelled
+func (a *App) deliverWebhooks(ctx context.Context) {
+	if a.waitForDB(ctx) != nil {
+		return
+	}
+
+	err := a.Webhooks.CreateTable(ctx)
+	if err != nil {
+		slog.Error("Couldn't create the webhook outbox, callbacks won't be sent", logging.Err(err))
+		return
 	}
 
-	return errors.New("Listen queue exited")
+	a.Webhooks.Run(ctx)
 }
 
-func (a *App) logOnError(v video.Video, err error) {
+// positiveIntFromEnv reads a positive integer from the environment, using def when it is unset
+func positiveIntFromEnv(key string, def int) (int, error) {
+	v := os.Getenv(key)
+	if v == "" {
+		return def, nil
+	}
+
+	i, err := strconv.Atoi(v)
+	if err != nil || i < 1 {
+		return 0, errors.New(fmt.Sprintf(TemplatePositive, key))
+	}
+
+	return i, nil
+}
+
+// nonNegativeIntFromEnv reads an integer of at least 0 from the environment, using def when it is unset
+func nonNegativeIntFromEnv(key string, def int) (int, error) {
+	v := os.Getenv(key)
+	if v == "" {
+		return def, nil
+	}
+
+	i, err := strconv.Atoi(v)
+	if err != nil || i < 0 {
+		return 0, errors.New(fmt.Sprintf(TemplateNonNegative, key))
+	}
+
+	return i, nil
+}
+
+// logOnError logs why a video failed, with the end of the failed command's stderr if there was one, and sets its status
+func (a *App) logOnError(ctx context.Context, v video.Video, err error) {
+	logger := logging.From(ctx)
 	if v == nil {
-		log.Printf("Error receiving video: %+v", err.Error())
+		logger.Error("Error receiving video", logging.Err(err))
 		return
 	}
 
 	r := v.GetRequest()
+	status := statusFor(err)
 
-	log.Printf("Error processing video %s: %+v", r.Id, err.Error())
+	logger.Error("Error processing video", "status", status, logging.Err(err), logging.KeyStderr, stderrOf(err))
 
-	err = r.SetStatus("error", a.DB)
+	err = r.SetStatus(status, a.DB)
 	if err != nil {
-		log.Printf("Error saving status on video %s: %+v", r.Id, err.Error())
+		logger.Error("Error saving the video's status", logging.Err(err))
 	}
 }
+
+// stderrOf gets the end of the stderr of the command that caused err, or "" if it wasn't a command
+func stderrOf(err error) string {
+	var cerr *command.Error
+	if errors.As(err, &cerr) {
+		return command.Tail(cerr.Stderr, 5)
+	}
+
+	return ""
+}
+
+// statusFor gets the status a failed video's row should have. Videos that broke a validation rule say which one
+func statusFor(err error) string {
+	var v *validate.Violation
+	if errors.As(err, &v) {
+		return v.Status
+	}
+
+	return "error"
+}
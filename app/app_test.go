@@ -0,0 +1,50 @@
+package app
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/therealpenguin/takeabow-upload-processor/timecode"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestProfilesOnlyNeedPrefixesByDefault(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "profiles.json")
+	err := os.WriteFile(path, []byte(`[{"name": "hd", "width": 1280, "height": 720, "prefix": "hd"}]`), 0644)
+	assert.NoError(t, err)
+
+	t.Setenv(EnvProfiles, path)
+	profiles, err := (&App{}).profilesFromEnv()
+	assert.NoError(t, err)
+	assert.Len(t, profiles, 1)
+
+	t.Setenv(EnvProfiles, "")
+	_, err = (&App{}).profilesFromEnv()
+	assert.Error(t, err)
+
+	profiles, err = (&App{ProcessedPrefix: "processed", SmallPrefix: "small"}).profilesFromEnv()
+	assert.NoError(t, err)
+	assert.Len(t, profiles, 2)
+}
+
+func TestThumbnailsCanBeJustThePoster(t *testing.T) {
+	t.Setenv(EnvThumbsPrefix, "thumbs")
+	t.Setenv(EnvThumbsCount, "0")
+	thumbs, err := thumbnailsFromEnv()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, thumbs.Count)
+
+	t.Setenv(EnvThumbsCount, "-1")
+	_, err = thumbnailsFromEnv()
+	assert.Error(t, err)
+
+	t.Setenv(EnvThumbsCount, "")
+	thumbs, err = thumbnailsFromEnv()
+	assert.NoError(t, err)
+	assert.Equal(t, 9, thumbs.Count)
+}
+
+func TestMigrateSlotsNeedsTheDefaultTimeline(t *testing.T) {
+	a := &App{Timelines: timecode.NewStore(&timecode.Timelines{Default: "missing"})}
+	assert.Error(t, a.MigrateSlots())
+}
@@ -0,0 +1,127 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"sync"
+)
+
+// fakeDB is a database that records the statements run on it and their arguments, and answers every query with status
+type fakeDB struct {
+	mu     sync.Mutex
+	execs  []string
+	args   [][]driver.Value
+	status string
+}
+
+// open gets a *sql.DB backed by f
+func (f *fakeDB) open() *sql.DB {
+	return sql.OpenDB(f)
+}
+
+// Execs gets the statements run so far
+func (f *fakeDB) Execs() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]string{}, f.execs...)
+}
+
+// Args gets the arguments of each statement run so far
+func (f *fakeDB) Args() [][]driver.Value {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([][]driver.Value{}, f.args...)
+}
+
+func (f *fakeDB) Connect(context.Context) (driver.Conn, error) {
+	return fakeConn{f}, nil
+}
+
+func (f *fakeDB) Driver() driver.Driver {
+	return fakeDriver{f}
+}
+
+type fakeDriver struct {
+	db *fakeDB
+}
+
+func (d fakeDriver) Open(string) (driver.Conn, error) {
+	return fakeConn{d.db}, nil
+}
+
+type fakeConn struct {
+	db *fakeDB
+}
+
+func (c fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return fakeStmt{c.db, query}, nil
+}
+
+func (c fakeConn) Close() error {
+	return nil
+}
+
+func (c fakeConn) Begin() (driver.Tx, error) {
+	return fakeTx{}, nil
+}
+
+type fakeTx struct{}
+
+func (fakeTx) Commit() error   { return nil }
+func (fakeTx) Rollback() error { return nil }
+
+type fakeStmt struct {
+	db    *fakeDB
+	query string
+}
+
+func (s fakeStmt) Close() error {
+	return nil
+}
+
+func (s fakeStmt) NumInput() int {
+	return -1
+}
+
+func (s fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	s.db.execs = append(s.db.execs, s.query)
+	s.db.args = append(s.db.args, args)
+	return driver.RowsAffected(1), nil
+}
+
+func (s fakeStmt) Query([]driver.Value) (driver.Rows, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	return &fakeRows{status: s.db.status}, nil
+}
+
+type fakeRows struct {
+	status string
+	read   bool
+}
+
+func (r *fakeRows) Columns() []string {
+	return []string{"status"}
+}
+
+func (r *fakeRows) Close() error {
+	return nil
+}
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.read || r.status == "" {
+		return io.EOF
+	}
+
+	r.read = true
+	dest[0] = r.status
+	return nil
+}
@@ -0,0 +1,26 @@
+package app
+
+import (
+	"fmt"
+	"github.com/therealpenguin/takeabow-upload-processor/logging"
+	"log/slog"
+)
+
+// MigrateSlots moves the clips in the slot sets of the first version into the registry's sets. Those sets, named with
+// string(slot), all belonged to the one timeline there was, which is now the default
+func (a *App) MigrateSlots() error {
+	timelines := a.Timelines.Load()
+	timeline, ok := timelines.Get(timelines.Default)
+	if !ok {
+		return fmt.Errorf("Default timeline %q isn't loaded", timelines.Default)
+	}
+
+	moved, err := a.Slots.Migrate(timeline.Name, timeline.Len())
+	if err != nil {
+		return err
+	}
+
+	slog.Info("Moved slot sets", logging.KeyTimeline, timeline.Name, "moved", moved)
+
+	return nil
+}
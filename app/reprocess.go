@@ -0,0 +1,455 @@
+package app
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/streadway/amqp"
+	"github.com/therealpenguin/takeabow-upload-processor/confirm"
+	"github.com/therealpenguin/takeabow-upload-processor/events"
+	"github.com/therealpenguin/takeabow-upload-processor/logging"
+	"github.com/therealpenguin/takeabow-upload-processor/tracing"
+	"github.com/therealpenguin/takeabow-upload-processor/video"
+	"github.com/therealpenguin/takeabow-upload-processor/webhook"
+	"go.opentelemetry.io/otel/attribute"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HeaderForce marks a message the reprocess command published that should start from the original
+const HeaderForce = "x-force"
+
+// ReprocessOptions picks which videos to process again, and how
+type ReprocessOptions struct {
+	// Statuses, Since, Until and IDs select rows of videos. Those left empty select every row
+	Statuses []string
+	Since    time.Time
+	Until    time.Time
+	IDs      []string
+
+	// Inline processes the videos here rather than publishing them onto the reprocess queue
+	Inline bool
+
+	// DryRun only logs what would be done
+	DryRun bool
+
+	// Concurrency is how many videos are processed or published at once
+	Concurrency int
+
+	// Checkpoint is a file the id of each video is written to once it's done. Videos already in it are skipped,
+	// so an interrupted run can be resumed
+	Checkpoint string
+
+	// Force gets the videos from their original source, rather than the rendition we already made. Without it, only
+	// videos whose rendition was made with other settings than the primary profile has now are got from their source.
+	// Videos processed before the settings were recorded are taken to have been rendered with the current ones
+	Force bool
+}
+
+// Reprocess runs the pipeline again for existing videos, either by publishing them onto the reprocess queue or inline
+func (a *App) Reprocess(ctx context.Context, o ReprocessOptions) error {
+	if o.Concurrency < 1 {
+		return errors.New("Concurrency must be at least 1")
+	}
+
+	// The rows are read with the columns the processor adds, so make sure they're there
+	err := video.Migrate(ctx, a.DB)
+	if err != nil {
+		return err
+	}
+
+	requests, err := a.selectVideos(ctx, o)
+	if err != nil {
+		return err
+	}
+
+	checkpoint, err := openCheckpoint(o.Checkpoint, o.DryRun)
+	if err != nil {
+		return err
+	}
+	defer checkpoint.Close()
+
+	do, cleanup, err := a.reprocessor(o)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	jobs := make(chan *video.VideoRequest)
+	var wg sync.WaitGroup
+	var reprocessed, failed int
+	var mu sync.Mutex
+
+	for i := 0; i < o.Concurrency; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for r := range jobs {
+				err := do(ctx, i, r)
+				if err != nil {
+					slog.Error("Error reprocessing video", logging.KeyVideoID, r.Id, logging.Err(err), logging.KeyStderr, stderrOf(err))
+					mu.Lock()
+					failed++
+					mu.Unlock()
+					continue
+				}
+
+				mu.Lock()
+				reprocessed++
+				mu.Unlock()
+
+				err = checkpoint.Done(r.Id)
+				if err != nil {
+					slog.Warn("Error saving checkpoint", logging.KeyVideoID, r.Id, logging.Err(err))
+				}
+			}
+		}(i)
+	}
+
+	skipped := 0
+	for _, r := range requests {
+		if checkpoint.Has(r.Id) {
+			skipped++
+			continue
+		}
+
+		a.reprocessFrom(r, o.Force || a.rerender(r))
+
+		select {
+		case jobs <- r:
+		case <-ctx.Done():
+		}
+
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	slog.Info("Reprocessed videos", "reprocessed", reprocessed, "failed", failed, "skipped", skipped)
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d videos failed", failed)
+	}
+
+	return nil
+}
+
+// reprocessor gets the function that reprocesses one video, with the clean up to do once they're all done.
+// The function is given which of the concurrent goroutines is calling it
+func (a *App) reprocessor(o ReprocessOptions) (func(context.Context, int, *video.VideoRequest) error, func(), error) {
+	if o.DryRun {
+		do := func(ctx context.Context, i int, r *video.VideoRequest) error {
+			how := "publish"
+			if o.Inline {
+				how = "process"
+			}
+			slog.Info("Would "+how+" video", logging.KeyVideoID, r.Id, "from", from(r))
+			return nil
+		}
+
+		return do, func() {}, nil
+	}
+
+	conn, err := amqp.Dial(a.AMQPUrl)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ch, err := conn.Channel()
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+
+	err = a.declareTopology(ch)
+	ch.Close()
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+
+	if o.Inline {
+		do, err := a.inlineReprocessor(conn, o.Concurrency)
+		if err != nil {
+			conn.Close()
+			return nil, nil, err
+		}
+
+		return do, func() { conn.Close() }, nil
+	}
+
+	// Each goroutine publishes on a channel of its own, as a channel's confirms can't be shared
+	channels := make([]*confirm.Channel, o.Concurrency)
+	for i := range channels {
+		channels[i], err = confirm.Open(conn)
+		if err != nil {
+			conn.Close()
+			return nil, nil, err
+		}
+	}
+
+	do := func(ctx context.Context, i int, r *video.VideoRequest) error {
+		slog.Info("Publishing video", logging.KeyVideoID, r.Id, "from", from(r))
+
+		ctx, span := tracing.Start(ctx, "publish reprocess", attribute.String("video_id", r.Id))
+		defer span.End()
+
+		headers := amqp.Table{HeaderForce: r.Force}
+		tracing.Inject(ctx, headers)
+
+		err := channels[i].Publish(ctx, "", QueueReprocess, amqp.Publishing{
+			Headers:      headers,
+			ContentType:  "application/json",
+			DeliveryMode: amqp.Persistent,
+			Body:         mustMarshal(r),
+		})
+		if err != nil {
+			// The channel may have gone with the error, so carry on with a new one
+			channels[i].Close()
+			if ch, oerr := confirm.Open(conn); oerr == nil {
+				channels[i] = ch
+			}
+		}
+
+		return err
+	}
+
+	return do, func() { conn.Close() }, nil
+}
+
+// inlineReprocessor gets the function that processes a video here, announcing it on conn and queueing its callback
+// just as the consumer does once it's done. A failed video isn't retried, it's counted and can be run again from the checkpoint
+func (a *App) inlineReprocessor(conn *amqp.Connection, concurrency int) (func(context.Context, int, *video.VideoRequest) error, error) {
+	a.mu.Lock()
+	a.conn = conn
+	a.mu.Unlock()
+
+	// Callbacks are signed, so without a secret there are none. They're sent by the outbox of the running processor
+	if a.WebhookSecret != "" {
+		a.Webhooks = webhook.NewOutbox(a.DB, webhook.NewSender(a.WebhookSecret))
+		err := a.Webhooks.CreateTable(context.Background())
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	workers := make([]*worker, concurrency)
+	for i := range workers {
+		w, err := a.newWorker(i)
+		if err != nil {
+			return nil, err
+		}
+		workers[i] = w
+	}
+
+	do := func(ctx context.Context, i int, r *video.VideoRequest) error {
+		v, err := video.FromRequest(r, a.Storage)
+		if err != nil {
+			return err
+		}
+
+		w := workers[i]
+		w.start(r.Id)
+		defer w.finish()
+
+		// A video that fails to be reprocessed keeps its status, as what was made of it before is still there.
+		// So there's nothing to announce, and a video cancelled part way through is left for the next run
+		err = w.process(ctx, v)
+		if err != nil {
+			return err
+		}
+
+		// There's no delivery, as there's only ever the one attempt
+		w.publish(ctx, events.TypeTranscoded, amqp.Delivery{}, v, nil)
+
+		return nil
+	}
+
+	return do, nil
+}
+
+// reprocessFrom sets where a video being reprocessed is got from. That's its primary rendition, unless force is set
+func (a *App) reprocessFrom(r *video.VideoRequest, force bool) {
+	r.Force = force
+	r.ProcessedKey = ""
+	if !force {
+		r.ProcessedKey = fmt.Sprintf("%s/%s.mp4", a.Profiles[0].Prefix, r.Id)
+	}
+}
+
+// rerender reports whether a video's primary rendition was rendered with other settings than the primary profile has now
+func (a *App) rerender(r *video.VideoRequest) bool {
+	return r.ProfileVersion != "" && r.ProfileVersion != a.Profiles[0].Version()
+}
+
+// forceOf reports whether a reprocessed delivery should start from the original
+func forceOf(d amqp.Delivery) bool {
+	v, _ := d.Headers[HeaderForce].(bool)
+	return v
+}
+
+// selectVideos gets the rows of videos the options pick, in id order
+func (a *App) selectVideos(ctx context.Context, o ReprocessOptions) ([]*video.VideoRequest, error) {
+	query, args := videosQuery(o)
+	rows, err := a.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	requests := make([]*video.VideoRequest, 0)
+	for rows.Next() {
+		var id string
+		var url, timeline, version sql.NullString
+		err := rows.Scan(&id, &url, &timeline, &version)
+		if err != nil {
+			return nil, err
+		}
+
+		// Split against the timeline the video was split against before, rather than the default
+		requests = append(requests, &video.VideoRequest{Id: id, Url: url.String, Timeline: timeline.String, ProfileVersion: version.String})
+	}
+
+	return requests, rows.Err()
+}
+
+// videosQuery builds the query that selects the rows of videos the options pick, with its arguments
+func videosQuery(o ReprocessOptions) (string, []interface{}) {
+	query := `SELECT id, original_url, timeline, profile_version FROM videos WHERE 1 = 1`
+	args := make([]interface{}, 0)
+
+	if len(o.Statuses) > 0 {
+		query += ` AND status IN (` + placeholders(len(o.Statuses)) + `)`
+		for _, s := range o.Statuses {
+			args = append(args, s)
+		}
+	}
+
+	if !o.Since.IsZero() {
+		query += ` AND created_at >= ?`
+		args = append(args, o.Since)
+	}
+
+	if !o.Until.IsZero() {
+		query += ` AND created_at < ?`
+		args = append(args, o.Until)
+	}
+
+	if len(o.IDs) > 0 {
+		query += ` AND id IN (` + placeholders(len(o.IDs)) + `)`
+		for _, id := range o.IDs {
+			args = append(args, id)
+		}
+	}
+
+	return query + ` ORDER BY id`, args
+}
+
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
+
+// from describes where a video being reprocessed will be got from
+func from(r *video.VideoRequest) string {
+	if r.ProcessedKey != "" {
+		return r.ProcessedKey
+	}
+
+	return r.Url
+}
+
+func mustMarshal(r *video.VideoRequest) []byte {
+	b, err := json.Marshal(r)
+	if err != nil {
+		panic(err)
+	}
+
+	return b
+}
+
+// checkpoint is the set of videos a reprocess has done, kept in a file with an id on each line
+type checkpoint struct {
+	done map[string]bool
+	f    *os.File
+	mu   sync.Mutex
+}
+
+// openCheckpoint reads the videos done so far from path, and opens it to add more unless this is a dry run.
+// An empty path keeps no checkpoint
+func openCheckpoint(path string, dryRun bool) (*checkpoint, error) {
+	c := &checkpoint{done: make(map[string]bool)}
+	if path == "" {
+		return c, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			if id := strings.TrimSpace(scanner.Text()); id != "" {
+				c.done[id] = true
+			}
+		}
+		f.Close()
+
+		if scanner.Err() != nil {
+			return nil, scanner.Err()
+		}
+	}
+
+	if dryRun {
+		return c, nil
+	}
+
+	c.f, err = os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// Has reports whether a video has already been done
+func (c *checkpoint) Has(id string) bool {
+	return c.done[id]
+}
+
+// Done records that a video has been done
+func (c *checkpoint) Done(id string) error {
+	if c.f == nil {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	_, err := fmt.Fprintln(c.f, id)
+	if err != nil {
+		return err
+	}
+
+	return c.f.Sync()
+}
+
+func (c *checkpoint) Close() error {
+	if c.f == nil {
+		return nil
+	}
+
+	return c.f.Close()
+}
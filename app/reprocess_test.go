@@ -0,0 +1,120 @@
+package app
+
+import (
+	"context"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+	"github.com/therealpenguin/takeabow-upload-processor/events"
+	"github.com/therealpenguin/takeabow-upload-processor/profile"
+	"github.com/therealpenguin/takeabow-upload-processor/steps"
+	"github.com/therealpenguin/takeabow-upload-processor/storage"
+	"github.com/therealpenguin/takeabow-upload-processor/video"
+	"gopkg.in/redis.v5"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReprocessKeyComesFromTheProcessor(t *testing.T) {
+	a := &App{Profiles: []profile.Profile{{Name: "720p", Prefix: "processed"}}}
+
+	// Whoever publishes a request can't point it at another key
+	r, err := video.NewVideoRequest([]byte(`{"id": "foo", "processed_key": "private/bar.mp4", "force": true}`))
+	assert.Nil(t, err)
+	assert.Equal(t, "", r.ProcessedKey)
+	assert.False(t, r.Force)
+
+	d := amqp.Delivery{Headers: amqp.Table{HeaderForce: false}}
+	a.reprocessFrom(r, forceOf(d))
+	assert.Equal(t, "processed/foo.mp4", r.ProcessedKey)
+
+	a.reprocessFrom(r, true)
+	assert.Equal(t, "", r.ProcessedKey)
+	assert.True(t, r.Force)
+}
+
+func TestReprocessRendersAgainWithNewSettings(t *testing.T) {
+	a := &App{Profiles: profile.Default("processed", "small")}
+	version := a.Profiles[0].Version()
+
+	assert.False(t, a.rerender(&video.VideoRequest{Id: "foo", ProfileVersion: version}))
+	assert.False(t, a.rerender(&video.VideoRequest{Id: "foo"}))
+
+	a.Profiles[0].CRF = 18
+	assert.True(t, a.rerender(&video.VideoRequest{Id: "foo", ProfileVersion: version}))
+}
+
+func TestInlineReprocessKeepsTheStatusOfAVideoThatFails(t *testing.T) {
+	db := &fakeDB{}
+	client := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	a := &App{TmpDir: t.TempDir(), DB: db.open(), Storage: storage.NewMemory(), Steps: steps.New(client, "bow")}
+	announced := 0
+	a.Events = events.NewPublisher(func() *amqp.Connection {
+		announced++
+		return nil
+	})
+
+	do, err := a.inlineReprocessor(nil, 1)
+	assert.NoError(t, err)
+
+	// Its rendition has gone, so it can't be reprocessed
+	r := &video.VideoRequest{Id: "abc", Url: "https://takeabow.s3.amazonaws.com/upload/abc.mp4", ProcessedKey: "processed/abc.mp4"}
+	assert.Error(t, do(context.Background(), 0, r))
+
+	for _, query := range db.Execs() {
+		assert.False(t, strings.Contains(query, "status"), query)
+	}
+	assert.Equal(t, 0, announced, "no event is published")
+}
+
+func TestPlaceholders(t *testing.T) {
+	assert.Equal(t, "?", placeholders(1))
+	assert.Equal(t, "?, ?, ?", placeholders(3))
+}
+
+func TestVideosQuery(t *testing.T) {
+	query, args := videosQuery(ReprocessOptions{})
+	assert.Equal(t, "SELECT id, original_url, timeline, profile_version FROM videos WHERE 1 = 1 ORDER BY id", query)
+	assert.Empty(t, args)
+
+	since := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	query, args = videosQuery(ReprocessOptions{
+		Statuses: []string{"transcoded", "error"},
+		Since:    since,
+		IDs:      []string{"foo"},
+	})
+	assert.Equal(t, "SELECT id, original_url, timeline, profile_version FROM videos WHERE 1 = 1 AND status IN (?, ?) AND created_at >= ? AND id IN (?) ORDER BY id", query)
+	assert.Equal(t, []interface{}{"transcoded", "error", since, "foo"}, args)
+}
+
+func TestCheckpointResumes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "checkpoint")
+
+	c, err := openCheckpoint(path, false)
+	assert.Nil(t, err)
+	assert.False(t, c.Has("foo"))
+	assert.Nil(t, c.Done("foo"))
+	assert.Nil(t, c.Done("bar"))
+	assert.Nil(t, c.Close())
+
+	c, err = openCheckpoint(path, false)
+	assert.Nil(t, err)
+	assert.True(t, c.Has("foo"))
+	assert.True(t, c.Has("bar"))
+	assert.False(t, c.Has("baz"))
+	assert.Nil(t, c.Close())
+
+	// A dry run reads the checkpoint, but doesn't add to it
+	c, err = openCheckpoint(path, true)
+	assert.Nil(t, err)
+	assert.True(t, c.Has("foo"))
+	assert.Nil(t, c.Done("baz"))
+	assert.Nil(t, c.Close())
+
+	c, _ = openCheckpoint(path, true)
+	assert.False(t, c.Has("baz"))
+}
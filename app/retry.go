@@ -0,0 +1,158 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/streadway/amqp"
+	"github.com/therealpenguin/takeabow-upload-processor/confirm"
+	"github.com/therealpenguin/takeabow-upload-processor/logging"
+	"github.com/therealpenguin/takeabow-upload-processor/metrics"
+	"github.com/therealpenguin/takeabow-upload-processor/retry"
+	"github.com/therealpenguin/takeabow-upload-processor/video"
+	"strconv"
+	"time"
+)
+
+const ExchangeDead = "uploads.dead"
+const QueueDead = "uploads.dead"
+
+// QueueDelayTemplate names a delay queue by the queue it goes back to and how many milliseconds it holds messages for,
+// so changing the delays declares new queues rather than clashing with the arguments of the old ones
+const QueueDelayTemplate = "%s.delay.%dms"
+
+const HeaderAttempt = "x-attempt"
+const HeaderError = "x-error"
+
+// MaxRetryDelay caps the exponential delay between attempts
+const MaxRetryDelay = time.Hour
+
+// fail either schedules the delivery for another attempt or dead-letters it, and then acks the original
+func (w *worker) fail(ctx context.Context, d amqp.Delivery, v video.Video, err error) {
+	a := w.app
+	attempt := attemptOf(d)
+	if v != nil {
+		ctx = logging.With(ctx, logging.KeyVideoID, v.GetRequest().Id, logging.KeySource, sourceOf(v))
+	}
+
+	if !retry.IsPermanent(err) && attempt < a.MaxAttempts {
+		perr := w.republish(d, "", delayQueue(w.queueOf(d), a.retryDelay(attempt)), amqp.Table{
+			HeaderAttempt: int32(attempt + 1),
+		})
+		if perr == nil {
+			logging.From(ctx).Warn("Retrying video", "max_attempts", a.MaxAttempts, logging.Err(err), logging.KeyStderr, stderrOf(err))
+			metrics.Retried.WithLabelValues(sourceOf(v)).Inc()
+			d.Ack(false)
+			return
+		}
+
+		// The video isn't failed, it goes back on the queue. Closing the channels has already put it back,
+		// unless there was no channel to close
+		logging.From(ctx).Error("Error scheduling retry", logging.Err(perr))
+		d.Nack(false, true)
+		return
+	}
+
+	// A video that fails to be reprocessed keeps its status, as what was made of it before is still there.
+	// So there's nothing to announce
+	announce := !w.isReprocess(d)
+	if announce {
+		a.logOnError(ctx, v, err)
+	} else {
+		logging.From(ctx).Error("Error reprocessing video, keeping its previous status", logging.Err(err), logging.KeyStderr, stderrOf(err))
+	}
+
+	// A rejected upload is an answer rather than a failure, so there's nothing to dead-letter
+	if status := statusFor(err); status != "error" {
+		metrics.Rejected.WithLabelValues(sourceOf(v), status).Inc()
+		if announce {
+			w.publish(ctx, eventFor(err), d, v, err)
+		}
+		d.Ack(false)
+		return
+	}
+
+	metrics.Failed.WithLabelValues(sourceOf(v)).Inc()
+	if announce {
+		w.publish(ctx, eventFor(err), d, v, err)
+	}
+
+	perr := w.republish(d, ExchangeDead, ChannelUploads, amqp.Table{
+		HeaderAttempt: int32(attempt),
+		HeaderError:   err.Error(),
+	})
+	if perr != nil {
+		// Leave it on the queue rather than lose it
+		logging.From(ctx).Error("Error dead-lettering delivery", logging.Err(perr))
+		d.Nack(false, true)
+		return
+	}
+
+	d.Ack(false)
+}
+
+// retryDelay gets how long to wait before trying a delivery again after the given attempt failed
+func (a *App) retryDelay(attempt int) time.Duration {
+	return retry.Backoff(attempt, a.RetryDelay, MaxRetryDelay)
+}
+
+// delayQueue gets the name of the queue that holds messages for delay before they go back to queue
+func delayQueue(queue string, delay time.Duration) string {
+	return fmt.Sprintf(QueueDelayTemplate, queue, delay.Milliseconds())
+}
+
+// republish copies a delivery onto an exchange, merging headers into the ones it already has.
+// It waits for the broker to confirm the copy, so the original is only acked once the copy is safe
+func (w *worker) republish(d amqp.Delivery, exchange, key string, headers amqp.Table) error {
+	pub := w.publisher()
+	if pub == nil {
+		return errors.New("No channel to republish on")
+	}
+
+	h := amqp.Table{}
+	for k, v := range d.Headers {
+		h[k] = v
+	}
+	for k, v := range headers {
+		h[k] = v
+	}
+
+	// Use a context of its own, so a copy isn't lost to the job being cancelled
+	ctx, cancel := context.WithTimeout(context.Background(), confirm.Timeout)
+	defer cancel()
+
+	err := pub.Publish(ctx, exchange, key, amqp.Publishing{
+		Headers:      h,
+		ContentType:  d.ContentType,
+		DeliveryMode: amqp.Persistent,
+		Body:         d.Body,
+	})
+	if err != nil {
+		// Close both channels for the worker to open new ones. The broker requeues the delivery when its channel closes
+		pub.Close()
+		if ch := w.channel(); ch != nil {
+			ch.Close()
+		}
+	}
+
+	return err
+}
+
+// attemptOf gets which attempt a delivery is. Deliveries without the header are on their first attempt
+func attemptOf(d amqp.Delivery) int {
+	switch v := d.Headers[HeaderAttempt].(type) {
+	case int32:
+		return int(v)
+	case int64:
+		return int(v)
+	case int:
+		return v
+	case string:
+		i, err := strconv.Atoi(v)
+		if err == nil {
+			return i
+		}
+	}
+
+	return 1
+}
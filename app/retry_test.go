@@ -0,0 +1,109 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+	"github.com/therealpenguin/takeabow-upload-processor/events"
+	"github.com/therealpenguin/takeabow-upload-processor/retry"
+	"github.com/therealpenguin/takeabow-upload-processor/storage"
+	"github.com/therealpenguin/takeabow-upload-processor/video"
+	"github.com/therealpenguin/takeabow-upload-processor/webhook"
+	"testing"
+	"time"
+)
+
+func TestDelayQueueNamedByDelay(t *testing.T) {
+	a := &App{RetryDelay: 30 * time.Second}
+
+	assert.Equal(t, "uploads.delay.30000ms", delayQueue(ChannelUploads, a.retryDelay(1)))
+	assert.Equal(t, "uploads.delay.60000ms", delayQueue(ChannelUploads, a.retryDelay(2)))
+	assert.Equal(t, "uploads.delay.3600000ms", delayQueue(ChannelUploads, a.retryDelay(20)))
+
+	// A different delay gets a different queue rather than redeclaring the old one
+	a.RetryDelay = 10 * time.Second
+	assert.Equal(t, "uploads.delay.10000ms", delayQueue(ChannelUploads, a.retryDelay(1)))
+
+	// Reprocessed videos wait in queues of their own, so they go back to the reprocess queue
+	assert.Equal(t, "uploads.reprocess.delay.10000ms", delayQueue(QueueReprocess, a.retryDelay(1)))
+}
+
+func TestAttemptOf(t *testing.T) {
+	assert.Equal(t, 1, attemptOf(amqp.Delivery{}))
+	assert.Equal(t, 3, attemptOf(amqp.Delivery{Headers: amqp.Table{HeaderAttempt: int32(3)}}))
+	assert.Equal(t, 4, attemptOf(amqp.Delivery{Headers: amqp.Table{HeaderAttempt: "4"}}))
+}
+
+// failingPublisher is a channel that never gets a message confirmed
+type failingPublisher struct {
+	published int
+}
+
+func (f *failingPublisher) Publish(ctx context.Context, exchange, key string, msg amqp.Publishing) error {
+	f.published++
+	return errors.New("channel closed")
+}
+
+func (f *failingPublisher) Close() error {
+	return nil
+}
+
+func TestFailLeavesVideoAloneWhenItCantBeRetried(t *testing.T) {
+	db := &fakeDB{}
+	a := newTestApp(t, time.Second)
+	a.DB = db.open()
+	a.MaxAttempts = 3
+	a.RetryDelay = time.Second
+	a.Webhooks = webhook.NewOutbox(a.DB, webhook.NewSender("secret"))
+
+	announced := 0
+	a.Events = events.NewPublisher(func() *amqp.Connection {
+		announced++
+		return nil
+	})
+
+	v, err := video.New([]byte(`{"id":"abc","url":"https://takeabow.s3.amazonaws.com/upload/abc.mp4","callback_url":"https://93.184.216.34/hook"}`), storage.NewMemory())
+	assert.NoError(t, err)
+
+	w := a.workers[0]
+	pub := &failingPublisher{}
+	w.pub = pub
+
+	ack := &acknowledger{}
+	w.fail(context.Background(), amqp.Delivery{Acknowledger: ack}, v, errors.New("ffmpeg exited"))
+
+	assert.Equal(t, 1, pub.published)
+	assert.Empty(t, db.Execs(), "no status or callback is written")
+	assert.Equal(t, 0, announced, "no event is published")
+	assert.Equal(t, 0, ack.acked)
+	assert.True(t, ack.requeue)
+}
+
+func TestFailKeepsTheStatusOfAReprocessedVideo(t *testing.T) {
+	db := &fakeDB{}
+	a := newTestApp(t, time.Second)
+	a.DB = db.open()
+	a.Webhooks = webhook.NewOutbox(a.DB, webhook.NewSender("secret"))
+
+	announced := 0
+	a.Events = events.NewPublisher(func() *amqp.Connection {
+		announced++
+		return nil
+	})
+
+	v, err := video.New([]byte(`{"id":"abc","url":"https://takeabow.s3.amazonaws.com/upload/abc.mp4","callback_url":"https://93.184.216.34/hook"}`), storage.NewMemory())
+	assert.NoError(t, err)
+
+	w := a.workers[0]
+	pub := &failingPublisher{}
+	w.pub = pub
+
+	ack := &acknowledger{}
+	d := amqp.Delivery{Acknowledger: ack, ConsumerTag: w.reprocessTag}
+	w.fail(context.Background(), d, v, retry.Permanent(errors.New("ffmpeg exited")))
+
+	assert.Empty(t, db.Execs(), "no status or callback is written")
+	assert.Equal(t, 0, announced, "no event is published")
+	assert.Equal(t, 1, pub.published, "it's still dead-lettered")
+}
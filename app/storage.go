@@ -0,0 +1,62 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/therealpenguin/takeabow-upload-processor/storage"
+)
+
+const StorageS3 = "s3"
+const StorageLocal = "local"
+
+// DefaultRegion is the AWS region used when BOW_AWS_REGION is unset
+const DefaultRegion = "eu-west-1"
+
+// newStorage creates the storage backend named by BOW_STORAGE, which defaults to S3. Every key it's given is cleaned first
+func (a *App) newStorage() (storage.Backend, error) {
+	b, err := a.newBackend()
+	if err != nil {
+		return nil, err
+	}
+
+	return storage.Clean(b), nil
+}
+
+// newBackend creates the backend named by BOW_STORAGE. The in-memory backend would throw every upload away, so it's only for tests
+func (a *App) newBackend() (storage.Backend, error) {
+	switch a.StorageKind {
+	case "", StorageS3:
+		a.StorageKind = StorageS3
+		if a.Bucket == "" {
+			return nil, errors.New(fmt.Sprintf(TemplateEmpty, EnvBucket))
+		}
+
+		if a.Region == "" {
+			a.Region = DefaultRegion
+		}
+
+		creds := credentials.NewEnvCredentials()
+		_, err := creds.Get()
+		if err != nil {
+			return nil, err
+		}
+
+		sess, err := session.NewSession(aws.NewConfig().WithRegion(a.Region).WithCredentials(creds))
+		if err != nil {
+			return nil, err
+		}
+
+		return storage.NewS3(sess, a.Bucket), nil
+	case StorageLocal:
+		if a.StorageDir == "" {
+			return nil, errors.New(fmt.Sprintf(TemplateEmpty, EnvStorageDir))
+		}
+
+		return storage.NewLocal(a.StorageDir), nil
+	}
+
+	return nil, fmt.Errorf("%s must be one of %s or %s", EnvStorage, StorageS3, StorageLocal)
+}
@@ -0,0 +1,16 @@
+package app
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestNewStorageRejectsMemory(t *testing.T) {
+	a := &App{StorageKind: "memory"}
+	_, err := a.newStorage()
+	assert.Error(t, err)
+
+	a = &App{StorageKind: StorageLocal, StorageDir: t.TempDir()}
+	_, err = a.newStorage()
+	assert.NoError(t, err)
+}
@@ -0,0 +1,263 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"github.com/streadway/amqp"
+	"github.com/therealpenguin/takeabow-upload-processor/logging"
+	"github.com/therealpenguin/takeabow-upload-processor/metrics"
+	"github.com/therealpenguin/takeabow-upload-processor/retry"
+	"github.com/therealpenguin/takeabow-upload-processor/video"
+	"github.com/therealpenguin/takeabow-upload-processor/webhook"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// ReconnectDelay is the first delay between reconnection attempts, doubling up to MaxReconnectDelay
+const ReconnectDelay = time.Second
+const MaxReconnectDelay = time.Minute
+
+// DBCheckInterval is how often the supervisor pings MySQL to keep track of whether it's up
+const DBCheckInterval = 10 * time.Second
+
+// Run supervises the RabbitMQ connection and the worker pool. Whenever the connection drops it re-dials,
+// re-declares the queues and hands the workers the new connection, keeping the rest of the app's state.
+// Cancelling ctx stops consuming; in-flight jobs get ShutdownGrace to finish before they are cancelled and requeued
+func (a *App) Run(ctx context.Context) error {
+	// Callbacks are signed, so without a secret there are none
+	if a.WebhookSecret != "" {
+		a.Webhooks = webhook.NewOutbox(a.DB, webhook.NewSender(a.WebhookSecret))
+	}
+
+	workers, err := a.newWorkers()
+	if err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	a.workers = workers
+	a.mu.Unlock()
+
+	jobs, cancelJobs := context.WithCancel(context.Background())
+	defer cancelJobs()
+
+	done := make(chan struct{})
+	defer close(done)
+	go a.shutdownOnCancel(ctx, done, cancelJobs)
+	go a.watchTimelines(ctx)
+	go a.serveAdmin(ctx)
+	go a.watchDB(ctx)
+	if a.Webhooks != nil {
+		go a.deliverWebhooks(ctx)
+	}
+
+	// Make sure the tables we write to are there before taking any deliveries
+	err = a.waitForDB(ctx)
+	if err != nil {
+		return nil
+	}
+
+	err = video.Migrate(ctx, a.DB)
+	if err != nil {
+		return fmt.Errorf("Couldn't migrate the database: %w", err)
+	}
+
+	for {
+		err := a.waitForDB(ctx)
+		if err != nil {
+			return nil
+		}
+
+		conn, err := a.connect(ctx)
+		if err != nil {
+			return nil
+		}
+
+		a.mu.Lock()
+		a.conn = conn
+		a.mu.Unlock()
+
+		err = a.serve(jobs, conn)
+		conn.Close()
+
+		if ctx.Err() != nil {
+			slog.Info("Shut down cleanly")
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		slog.Warn("Lost connection to RabbitMQ, reconnecting", "delay", ReconnectDelay.String())
+		metrics.Reconnects.Inc()
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(ReconnectDelay):
+		}
+	}
+}
+
+// connection gets the current connection to RabbitMQ, or nil if there isn't one
+func (a *App) connection() *amqp.Connection {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.conn
+}
+
+// connect dials RabbitMQ with backoff until it succeeds or ctx is cancelled
+func (a *App) connect(ctx context.Context) (*amqp.Connection, error) {
+	for attempt := 1; ; attempt++ {
+		conn, err := amqp.Dial(a.AMQPUrl)
+		if err == nil {
+			return conn, nil
+		}
+
+		delay := retry.Backoff(attempt, ReconnectDelay, MaxReconnectDelay)
+		slog.Warn("Couldn't connect to RabbitMQ, retrying", "delay", delay.String(), logging.Err(err))
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+// waitForDB pings MySQL with backoff until it answers or ctx is cancelled
+func (a *App) waitForDB(ctx context.Context) error {
+	for attempt := 1; ; attempt++ {
+		err := a.DB.PingContext(ctx)
+		a.dbUp.Store(err == nil)
+		if err == nil {
+			return nil
+		}
+
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		delay := retry.Backoff(attempt, ReconnectDelay, MaxReconnectDelay)
+		slog.Warn("Couldn't ping MySQL, retrying", "delay", delay.String(), logging.Err(err))
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+// watchDB pings MySQL every DBCheckInterval, so workers know whether it's up without pinging it for every delivery
+func (a *App) watchDB(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(DBCheckInterval):
+		}
+
+		err := a.DB.PingContext(ctx)
+		if err != nil && ctx.Err() == nil && a.dbUp.Load() {
+			slog.Warn("Lost connection to MySQL", logging.Err(err))
+		}
+		a.dbUp.Store(err == nil)
+	}
+}
+
+// dbReady waits for MySQL if the supervisor last saw it down
+func (a *App) dbReady(ctx context.Context) error {
+	if a.dbUp.Load() {
+		return nil
+	}
+
+	return a.waitForDB(ctx)
+}
+
+// serve declares the queues on conn and runs the workers on it until it closes or they are stopped
+func (a *App) serve(ctx context.Context, conn *amqp.Connection) error {
+	closed := conn.NotifyClose(make(chan *amqp.Error, 1))
+
+	// A connection that dropped in the meantime is reconnected, anything else is an error
+	ch, err := conn.Channel()
+	if err != nil {
+		if conn.IsClosed() {
+			return nil
+		}
+		return err
+	}
+
+	err = a.declareTopology(ch)
+	ch.Close()
+
+	if err != nil {
+		return err
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, a.Workers)
+
+	a.mu.Lock()
+	for _, w := range a.workers {
+		wg.Add(1)
+		go func(w *worker) {
+			defer wg.Done()
+			if err := w.run(ctx, conn); err != nil {
+				errs <- err
+			}
+		}(w)
+	}
+	a.mu.Unlock()
+
+	slog.Info("Listening for uploads", "queues", []string{ChannelUploads, QueueReprocess}, "workers", a.Workers)
+
+	go func() {
+		cerr, ok := <-closed
+		if ok {
+			slog.Warn("RabbitMQ connection closed", logging.Err(cerr))
+		}
+	}()
+
+	wg.Wait()
+	close(errs)
+
+	err, ok := <-errs
+	if ok && !conn.IsClosed() {
+		return err
+	}
+
+	return nil
+}
+
+// shutdownOnCancel stops the workers when ctx is cancelled, and cancels their jobs if they outlast the grace period
+func (a *App) shutdownOnCancel(ctx context.Context, done <-chan struct{}, cancelJobs context.CancelFunc) {
+	select {
+	case <-ctx.Done():
+	case <-done:
+		return
+	}
+
+	slog.Info("Shutting down, waiting for in-flight jobs", "grace", a.ShutdownGrace.String())
+	a.Stop()
+
+	select {
+	case <-time.After(a.ShutdownGrace):
+		slog.Warn("Grace period expired, cancelling in-flight jobs")
+		cancelJobs()
+	case <-done:
+	}
+}
+
+// Stop tells every worker to stop consuming. In-flight deliveries are finished before Run returns
+func (a *App) Stop() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, w := range a.workers {
+		w.stop()
+	}
+}
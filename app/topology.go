@@ -0,0 +1,119 @@
+package app
+
+import (
+	"github.com/streadway/amqp"
+	"github.com/therealpenguin/takeabow-upload-processor/events"
+	"github.com/therealpenguin/takeabow-upload-processor/progress"
+	"time"
+)
+
+// declareTopology declares the uploads and reprocess queues with a delay queue per retry attempt each, the dead-letter exchange and,
+// if it's wanted, the progress exchange. Each delay queue holds messages for its backoff and then dead-letters them back onto its queue
+func (a *App) declareTopology(ch *amqp.Channel) error {
+	for _, queue := range []string{ChannelUploads, QueueReprocess} {
+		err := a.declareQueue(ch, queue)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := ch.ExchangeDeclare(
+		ExchangeDead, // name
+		"fanout",     // kind
+		true,         // durable
+		false,        // auto-delete
+		false,        // internal
+		false,        // no-wait
+		nil,          // arguments
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = ch.QueueDeclare(
+		QueueDead, // name
+		true,      // durable
+		false,     // delete when unused
+		false,     // exclusive
+		false,     // no-wait
+		nil,       // arguments
+	)
+	if err != nil {
+		return err
+	}
+
+	err = ch.QueueBind(QueueDead, "", ExchangeDead, false, nil)
+	if err != nil {
+		return err
+	}
+
+	err = ch.ExchangeDeclare(
+		events.ExchangeEvents, // name
+		"topic",               // kind
+		true,                  // durable
+		false,                 // auto-delete
+		false,                 // internal
+		false,                 // no-wait
+		nil,                   // arguments
+	)
+	if err != nil {
+		return err
+	}
+
+	if !a.ProgressAMQP {
+		return nil
+	}
+
+	return ch.ExchangeDeclare(
+		progress.ExchangeProgress, // name
+		"topic",                   // kind
+		true,                      // durable
+		false,                     // auto-delete
+		false,                     // internal
+		false,                     // no-wait
+		nil,                       // arguments
+	)
+}
+
+// declareQueue declares a queue that workers consume, with the delay queues its deliveries wait in to be retried
+func (a *App) declareQueue(ch *amqp.Channel, queue string) error {
+	_, err := ch.QueueDeclare(
+		queue, // name
+		true,  // durable
+		false, // delete when unused
+		false, // exclusive
+		false, // no-wait
+		nil,   // arguments
+	)
+	if err != nil {
+		return err
+	}
+
+	// Attempts past the cap share a queue
+	declared := map[time.Duration]bool{}
+	for attempt := 1; attempt < a.MaxAttempts; attempt++ {
+		delay := a.retryDelay(attempt)
+		if declared[delay] {
+			continue
+		}
+		declared[delay] = true
+
+		_, err = ch.QueueDeclare(
+			delayQueue(queue, delay), // name
+			true,                     // durable
+			false,                    // delete when unused
+			false,                    // exclusive
+			false,                    // no-wait
+			amqp.Table{
+				"x-message-ttl":             int64(delay / time.Millisecond),
+				"x-dead-letter-exchange":    "",
+				"x-dead-letter-routing-key": queue,
+			},
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,84 @@
+package app
+
+import (
+	"fmt"
+	"github.com/therealpenguin/takeabow-upload-processor/validate"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const EnvMinDuration = "BOW_MIN_DURATION"
+const EnvMaxDuration = "BOW_MAX_DURATION"
+const EnvRequireVideo = "BOW_REQUIRE_VIDEO"
+const EnvMaxWidth = "BOW_MAX_WIDTH"
+const EnvMaxHeight = "BOW_MAX_HEIGHT"
+const EnvMaxFileSize = "BOW_MAX_FILE_SIZE"
+const EnvAllowedContainers = "BOW_ALLOWED_CONTAINERS"
+const EnvAllowedVideoCodecs = "BOW_ALLOWED_VIDEO_CODECS"
+const EnvAllowedAudioCodecs = "BOW_ALLOWED_AUDIO_CODECS"
+
+const TemplateNumber = "%s must be a number"
+
+// rulesFromEnv reads the validation rules. Anything unset is unlimited, except that a video stream is required by default
+func rulesFromEnv() (validate.Rules, error) {
+	var err error
+	r := validate.Rules{
+		RequireVideo: os.Getenv(EnvRequireVideo) != "false",
+		Containers:   listFromEnv(EnvAllowedContainers),
+		VideoCodecs:  listFromEnv(EnvAllowedVideoCodecs),
+		AudioCodecs:  listFromEnv(EnvAllowedAudioCodecs),
+	}
+
+	if r.MinDuration, err = floatFromEnv(EnvMinDuration); err != nil {
+		return r, err
+	}
+
+	if r.MaxDuration, err = floatFromEnv(EnvMaxDuration); err != nil {
+		return r, err
+	}
+
+	if r.MaxWidth, err = positiveIntFromEnv(EnvMaxWidth, 0); err != nil {
+		return r, err
+	}
+
+	if r.MaxHeight, err = positiveIntFromEnv(EnvMaxHeight, 0); err != nil {
+		return r, err
+	}
+
+	size, err := floatFromEnv(EnvMaxFileSize)
+	if err != nil {
+		return r, err
+	}
+	r.MaxFileSize = int64(size)
+
+	return r, nil
+}
+
+// floatFromEnv reads a non-negative number from the environment, using 0 when it is unset
+func floatFromEnv(key string) (float64, error) {
+	v := os.Getenv(key)
+	if v == "" {
+		return 0, nil
+	}
+
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil || f < 0 {
+		return 0, fmt.Errorf(TemplateNumber, key)
+	}
+
+	return f, nil
+}
+
+// listFromEnv reads a comma separated list from the environment
+func listFromEnv(key string) []string {
+	list := make([]string, 0)
+	for _, item := range strings.Split(os.Getenv(key), ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			list = append(list, item)
+		}
+	}
+
+	return list
+}
@@ -0,0 +1,593 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/streadway/amqp"
+	"github.com/therealpenguin/takeabow-upload-processor/confirm"
+	"github.com/therealpenguin/takeabow-upload-processor/events"
+	"github.com/therealpenguin/takeabow-upload-processor/logging"
+	"github.com/therealpenguin/takeabow-upload-processor/metrics"
+	"github.com/therealpenguin/takeabow-upload-processor/processor"
+	"github.com/therealpenguin/takeabow-upload-processor/progress"
+	"github.com/therealpenguin/takeabow-upload-processor/retry"
+	"github.com/therealpenguin/takeabow-upload-processor/tracing"
+	"github.com/therealpenguin/takeabow-upload-processor/video"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// worker consumes the uploads and reprocess queues on its own channel, with its own prefetch budget and temp directory.
+// A worker outlives the connection it consumes on, so the supervisor can hand it a new one after a reconnect
+type worker struct {
+	id  int
+	app *App
+	// tag and reprocessTag are the worker's consumers of the uploads and reprocess queues
+	tag          string
+	reprocessTag string
+	processor    *processor.Processor
+	quit         chan struct{}
+	once         sync.Once
+	mu           sync.Mutex
+	ch           *amqp.Channel
+	pub          publisher
+	job          *Job
+}
+
+// publisher is what a worker republishes deliveries on, a confirm.Channel outside tests
+type publisher interface {
+	Publish(ctx context.Context, exchange, key string, msg amqp.Publishing) error
+	Close() error
+}
+
+// Job is the video a worker is processing, and how far through it is
+type Job struct {
+	Worker    int       `json:"worker"`
+	VideoID   string    `json:"id"`
+	Step      string    `json:"step"`
+	Percent   float64   `json:"percent"`
+	StartedAt time.Time `json:"started_at"`
+	Elapsed   string    `json:"elapsed"`
+}
+
+// newWorker makes the worker's temp directory and processor
+func (a *App) newWorker(id int) (*worker, error) {
+	dir := filepath.Join(a.TmpDir, fmt.Sprintf("worker-%d", id))
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	tag := fmt.Sprintf("upload-processor-%d-%d", os.Getpid(), id)
+	w := &worker{
+		id:           id,
+		app:          a,
+		tag:          tag,
+		reprocessTag: tag + "-reprocess",
+		quit:         make(chan struct{}),
+	}
+
+	reporter := progress.Multi{progress.NewRedis(a.Redis, a.RedisConfig.Namespace), w}
+	if a.ProgressAMQP {
+		reporter = append(reporter, progress.NewAMQP(w.channel))
+	}
+
+	w.processor = processor.New(processor.Config{
+		Storage:     a.Storage,
+		Dir:         dir,
+		Profiles:    a.Profiles,
+		Rules:       a.Rules,
+		SplitPrefix: a.SplitPrefix,
+		Slots:       a.Slots,
+		Steps:       a.Steps,
+		Timelines:   a.Timelines,
+		Thumbnails:  a.Thumbnails,
+		Progress:    reporter,
+		Strategy:    a.Strategy,
+	})
+
+	return w, nil
+}
+
+// newWorkers makes the pool of Workers workers
+func (a *App) newWorkers() ([]*worker, error) {
+	workers := make([]*worker, 0, a.Workers)
+	for i := 0; i < a.Workers; i++ {
+		w, err := a.newWorker(i)
+		if err != nil {
+			return nil, err
+		}
+		workers = append(workers, w)
+	}
+
+	return workers, nil
+}
+
+// consume opens a channel on conn and starts consuming the uploads and reprocess queues on it.
+// Deliveries are retried and dead-lettered on a second channel, in confirm mode so none are acked before they're safely republished
+func (w *worker) consume(conn *amqp.Connection) (<-chan amqp.Delivery, chan *amqp.Error, error) {
+	pub, err := confirm.Open(conn)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ch, err := conn.Channel()
+	if err != nil {
+		pub.Close()
+		return nil, nil, err
+	}
+
+	// The prefetch budget is shared by both consumers
+	err = ch.Qos(
+		w.app.Prefetch, // prefetch count
+		0,              // prefetch size
+		true,           // global
+	)
+	if err != nil {
+		ch.Close()
+		pub.Close()
+		return nil, nil, err
+	}
+
+	uploads, err := ch.Consume(
+		ChannelUploads, // queue
+		w.tag,          // consumer
+		false,          // auto-ack
+		false,          // exclusive
+		false,          // no-local
+		false,          // no-wait
+		nil,            // args
+	)
+	if err != nil {
+		ch.Close()
+		pub.Close()
+		return nil, nil, err
+	}
+
+	reprocess, err := ch.Consume(
+		QueueReprocess, // queue
+		w.reprocessTag, // consumer
+		false,          // auto-ack
+		false,          // exclusive
+		false,          // no-local
+		false,          // no-wait
+		nil,            // args
+	)
+	if err != nil {
+		ch.Close()
+		pub.Close()
+		return nil, nil, err
+	}
+
+	w.mu.Lock()
+	w.ch = ch
+	w.pub = pub
+	w.mu.Unlock()
+
+	return merge(uploads, reprocess), ch.NotifyClose(make(chan *amqp.Error, 1)), nil
+}
+
+// merge forwards the deliveries of both consumers onto one channel, which is closed once both of theirs are
+func merge(uploads, reprocess <-chan amqp.Delivery) <-chan amqp.Delivery {
+	msgs := make(chan amqp.Delivery)
+
+	var wg sync.WaitGroup
+	for _, deliveries := range []<-chan amqp.Delivery{uploads, reprocess} {
+		wg.Add(1)
+		go func(deliveries <-chan amqp.Delivery) {
+			defer wg.Done()
+			for d := range deliveries {
+				msgs <- d
+			}
+		}(deliveries)
+	}
+
+	go func() {
+		wg.Wait()
+		close(msgs)
+	}()
+
+	return msgs
+}
+
+// run handles deliveries one at a time until conn closes or the worker is stopped.
+// If only the worker's channel closes, it opens a new one on the same connection
+func (w *worker) run(ctx context.Context, conn *amqp.Connection) error {
+	for attempt := 1; ; attempt++ {
+		if w.stopped() || conn.IsClosed() {
+			return nil
+		}
+
+		msgs, closed, err := w.consume(conn)
+		if err != nil {
+			if conn.IsClosed() {
+				return nil
+			}
+
+			delay := retry.Backoff(attempt, ReconnectDelay, MaxReconnectDelay)
+			slog.Warn("Worker couldn't open a channel, retrying", "worker", w.id, "delay", delay.String(), logging.Err(err))
+			select {
+			case <-time.After(delay):
+			case <-w.quit:
+			}
+			continue
+		}
+
+		attempt = 0
+		err = w.serve(ctx, msgs)
+		w.channel().Close()
+		w.publisher().Close()
+
+		if err != nil {
+			return err
+		}
+
+		select {
+		case cerr, ok := <-closed:
+			if ok && !conn.IsClosed() {
+				slog.Warn("Worker lost its channel, reopening", "worker", w.id, logging.Err(cerr))
+			}
+		default:
+		}
+	}
+}
+
+// serve handles deliveries until msgs closes or the worker is stopped
+func (w *worker) serve(ctx context.Context, msgs <-chan amqp.Delivery) error {
+	for {
+		select {
+		case <-w.quit:
+			return w.drain(msgs)
+		default:
+		}
+
+		select {
+		case <-w.quit:
+			return w.drain(msgs)
+		case d, ok := <-msgs:
+			if !ok {
+				return nil
+			}
+			w.handle(ctx, d)
+		}
+	}
+}
+
+// channel gets the channel the worker is currently consuming on
+func (w *worker) channel() *amqp.Channel {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.ch
+}
+
+// publisher gets the channel the worker republishes deliveries on
+func (w *worker) publisher() publisher {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.pub
+}
+
+// stopped reports whether stop has been called
+func (w *worker) stopped() bool {
+	select {
+	case <-w.quit:
+		return true
+	default:
+		return false
+	}
+}
+
+// handle processes a single delivery and reports the outcome on the video's row
+func (w *worker) handle(ctx context.Context, d amqp.Delivery) {
+	a := w.app
+
+	// Carry on the publisher's trace, if it started one
+	ctx, span := tracing.Start(tracing.Extract(ctx, d.Headers), "process upload", attribute.Int("attempt", attemptOf(d)))
+	defer span.End()
+
+	// Hold on to the delivery until MySQL is back, rather than failing it
+	err := a.dbReady(ctx)
+	if err != nil {
+		d.Nack(false, true)
+		return
+	}
+
+	r, err := video.NewVideoRequest(d.Body)
+	if err != nil {
+		w.fail(ctx, d, nil, retry.Permanent(err))
+		return
+	}
+
+	reprocess := w.isReprocess(d)
+	if reprocess {
+		a.reprocessFrom(r, forceOf(d))
+	}
+
+	v, err := video.FromRequest(r, a.Storage)
+	if err != nil {
+		w.fail(ctx, d, nil, retry.Permanent(err))
+		return
+	}
+
+	ctx = logging.With(ctx, logging.KeyAttempt, attemptOf(d), "worker", w.id)
+
+	w.start(r.Id)
+	defer w.finish()
+
+	// A video that's already transcoded is a duplicate message, unless it's being reprocessed
+	if !reprocess {
+		status, err := r.GetStatus(a.DB)
+		if err == nil && status == "transcoded" {
+			logging.From(ctx).Info("Skipping video, it's already transcoded", logging.KeyVideoID, r.Id)
+			d.Ack(false)
+			return
+		}
+	}
+
+	err = w.process(ctx, v)
+	w.settle(ctx, d, v, err)
+}
+
+// settle acks, retries or dead-letters a delivery once its video has been processed.
+// A video cancelled part way through, such as by a shutdown outlasting its grace period, goes back on the queue
+func (w *worker) settle(ctx context.Context, d amqp.Delivery, v video.Video, err error) {
+	if err != nil && ctx.Err() != nil {
+		logging.From(ctx).Warn("Requeueing video, it was cancelled", logging.KeyVideoID, v.GetRequest().Id, logging.Err(err))
+		d.Nack(false, true)
+		return
+	}
+
+	if err != nil {
+		w.fail(ctx, d, v, err)
+		return
+	}
+
+	w.publish(ctx, events.TypeTranscoded, d, v, nil)
+	d.Ack(false)
+}
+
+// publish announces how a delivery ended, and queues a callback if the video asked for one.
+// It's been handled either way, so an event that isn't confirmed is logged and counted rather than retried
+func (w *worker) publish(ctx context.Context, typ string, d amqp.Delivery, v video.Video, err error) {
+	if v == nil {
+		return
+	}
+
+	finished := time.Now()
+	started := finished
+	if job := w.current(); job != nil {
+		started = job.StartedAt
+	}
+
+	e := events.New(typ, v.GetRequest(), attemptOf(d), started, finished)
+	e.Status = "transcoded"
+	if err != nil {
+		e.Status = statusFor(err)
+		e.Error = err.Error()
+	}
+
+	// Use a deadline of its own, so the outcome of a job that was cancelled is still announced and queued. The trace is kept
+	pctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), confirm.Timeout)
+	defer cancel()
+
+	perr := w.app.Events.Publish(pctx, e)
+	if perr != nil {
+		metrics.EventsFailed.WithLabelValues(typ).Inc()
+		logging.From(ctx).Error("Error publishing event", "event", typ, logging.Err(perr))
+	}
+
+	w.enqueueCallback(pctx, e, v.GetRequest().CallbackURL)
+}
+
+// eventFor gets the type of event that announces how processing a video ended
+func eventFor(err error) string {
+	if err == nil {
+		return events.TypeTranscoded
+	}
+
+	// A rejected upload is an answer rather than a failure
+	if statusFor(err) != "error" {
+		return events.TypeRejected
+	}
+
+	return events.TypeFailed
+}
+
+// enqueueCallback stores the event in the webhook outbox to be POSTed to callback
+func (w *worker) enqueueCallback(ctx context.Context, e events.Event, callback string) {
+	if callback == "" {
+		return
+	}
+
+	if w.app.Webhooks == nil {
+		logging.From(ctx).Warn("Video asked for a callback, but there's no webhook secret to sign it with", "callback", callback)
+		return
+	}
+
+	// Partners get the status, which is one of a few stable codes, rather than the error, which can carry
+	// command lines, paths and keys
+	e.Error = ""
+
+	body, err := json.Marshal(e)
+	if err == nil {
+		err = w.app.Webhooks.Enqueue(ctx, e.VideoID, callback, e.Type, body)
+	}
+	if err != nil {
+		logging.From(ctx).Error("Error queueing callback", "callback", callback, logging.Err(err))
+	}
+}
+
+// process transcodes and splits a video, and records what was made of it on its row
+func (w *worker) process(ctx context.Context, v video.Video) error {
+	a := w.app
+	r := v.GetRequest()
+
+	ctx = logging.With(ctx, logging.KeyVideoID, r.Id, logging.KeySource, sourceOf(v), "trace_id", tracing.TraceID(ctx))
+	trace.SpanFromContext(ctx).SetAttributes(attribute.String("video_id", r.Id), attribute.String("source", sourceOf(v)))
+
+	metrics.InFlight.Inc()
+	defer metrics.InFlight.Dec()
+
+	r.SetOriginalUrl(a.DB)
+	err := w.processor.Process(ctx, v)
+	if err != nil {
+		trace.SpanFromContext(ctx).RecordError(err)
+		trace.SpanFromContext(ctx).SetStatus(codes.Error, err.Error())
+		return err
+	}
+
+	err = r.SaveDuration(a.DB)
+	if err != nil {
+		return fmt.Errorf("Error saving the video's duration: %w", err)
+	}
+
+	err = r.SaveProfileVersion(a.DB)
+	if err != nil {
+		return fmt.Errorf("Error saving the video's profile version: %w", err)
+	}
+
+	err = r.SaveTimeline(a.DB)
+	if err != nil {
+		return fmt.Errorf("Error saving the video's timeline: %w", err)
+	}
+
+	err = r.SaveSplits(a.DB)
+	if err != nil {
+		return fmt.Errorf("Error saving the video's splits: %w", err)
+	}
+
+	if r.Poster != "" {
+		err = r.SaveThumbnails(a.DB)
+		if err != nil {
+			return fmt.Errorf("Error saving the video's thumbnails: %w", err)
+		}
+	}
+
+	// Only once everything about it is saved, as a transcoded video's redelivery is skipped
+	err = r.SetStatus("transcoded", a.DB)
+	if err != nil {
+		return err
+	}
+
+	metrics.Processed.WithLabelValues(sourceOf(v)).Inc()
+	logging.From(ctx).Info("Done processing video", "duration", r.Duration, "splits", len(r.Splits))
+
+	// The video is done, so a later reprocess starts from the beginning
+	err = a.Steps.Clear(r.Id)
+	if err != nil {
+		logging.From(ctx).Warn("Error clearing the video's steps", logging.Err(err))
+	}
+
+	return nil
+}
+
+// start records that the worker is processing a video
+func (w *worker) start(id string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.job = &Job{Worker: w.id, VideoID: id, StartedAt: time.Now()}
+}
+
+// finish records that the worker is idle
+func (w *worker) finish() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.job = nil
+}
+
+// Report keeps the step of the worker's job up to date from its progress
+func (w *worker) Report(rep progress.Report) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.job != nil && w.job.VideoID == rep.VideoID {
+		w.job.Step = rep.Step
+		w.job.Percent = rep.Percent
+	}
+
+	return nil
+}
+
+// current gets a copy of the worker's job, or nil if it's idle
+func (w *worker) current() *Job {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.job == nil {
+		return nil
+	}
+
+	job := *w.job
+	job.Elapsed = time.Since(job.StartedAt).Round(time.Second).String()
+	return &job
+}
+
+// stop cancels the worker's consumers so no new deliveries arrive
+func (w *worker) stop() {
+	w.once.Do(func() {
+		close(w.quit)
+
+		ch := w.channel()
+		if ch == nil {
+			return
+		}
+
+		for _, tag := range []string{w.tag, w.reprocessTag} {
+			err := ch.Cancel(tag, false)
+			if err != nil {
+				slog.Error("Error cancelling worker", "worker", w.id, "consumer", tag, logging.Err(err))
+			}
+		}
+	})
+}
+
+// isReprocess reports whether a delivery came from the reprocess queue
+func (w *worker) isReprocess(d amqp.Delivery) bool {
+	return d.ConsumerTag == w.reprocessTag
+}
+
+// queueOf gets the queue a delivery came from
+func (w *worker) queueOf(d amqp.Delivery) string {
+	if w.isReprocess(d) {
+		return QueueReprocess
+	}
+
+	return ChannelUploads
+}
+
+// drain hands back any prefetched deliveries the worker hasn't started, so another consumer can take them
+func (w *worker) drain(msgs <-chan amqp.Delivery) error {
+	for d := range msgs {
+		err := d.Nack(false, true)
+		if err != nil {
+			return err
+		}
+	}
+
+	slog.Info("Worker stopped", "worker", w.id)
+	return nil
+}
+
+// sourceOf gets where a video came from, for labelling metrics
+func sourceOf(v video.Video) string {
+	if v == nil {
+		return "unknown"
+	}
+
+	if source := v.GetRequest().GetSource(); source != "" {
+		return string(source)
+	}
+
+	return "unknown"
+}
@@ -0,0 +1,202 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+	"github.com/therealpenguin/takeabow-upload-processor/events"
+	"github.com/therealpenguin/takeabow-upload-processor/profile"
+	"github.com/therealpenguin/takeabow-upload-processor/steps"
+	"github.com/therealpenguin/takeabow-upload-processor/storage"
+	"github.com/therealpenguin/takeabow-upload-processor/validate"
+	"github.com/therealpenguin/takeabow-upload-processor/webhook"
+	"gopkg.in/redis.v5"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPoolSizeFromEnv(t *testing.T) {
+	t.Setenv(EnvWorkers, "")
+	workers, err := positiveIntFromEnv(EnvWorkers, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, workers)
+
+	t.Setenv(EnvPrefetch, "4")
+	prefetch, err := positiveIntFromEnv(EnvPrefetch, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, prefetch)
+
+	for _, v := range []string{"0", "-2", "many"} {
+		t.Setenv(EnvWorkers, v)
+		_, err = positiveIntFromEnv(EnvWorkers, 1)
+		assert.Error(t, err, v)
+	}
+}
+
+func TestNewWorkers(t *testing.T) {
+	a := &App{TmpDir: t.TempDir(), Workers: 3}
+
+	workers, err := a.newWorkers()
+	assert.NoError(t, err)
+	assert.Len(t, workers, 3)
+
+	tags := map[string]bool{}
+	for i, w := range workers {
+		assert.Equal(t, i, w.id)
+		tags[w.tag] = true
+		tags[w.reprocessTag] = true
+
+		// Every worker gets a temp directory of its own
+		info, err := os.Stat(filepath.Join(a.TmpDir, fmt.Sprintf("worker-%d", i)))
+		assert.NoError(t, err)
+		assert.True(t, info.IsDir())
+	}
+	assert.Len(t, tags, 6)
+}
+
+func TestEventFor(t *testing.T) {
+	assert.Equal(t, events.TypeTranscoded, eventFor(nil))
+	assert.Equal(t, events.TypeRejected, eventFor(fmt.Errorf("validating: %w", &validate.Violation{Status: "rejected", Reason: "too long"})))
+	assert.Equal(t, events.TypeFailed, eventFor(errors.New("ffmpeg exited")))
+}
+
+// blockingAcknowledger records what was done with a delivery, holding the worker up until release is closed
+type blockingAcknowledger struct {
+	acknowledger
+	started chan struct{}
+	release chan struct{}
+}
+
+func (b *blockingAcknowledger) Nack(tag uint64, multiple, requeue bool) error {
+	close(b.started)
+	<-b.release
+	return b.acknowledger.Nack(tag, multiple, requeue)
+}
+
+func TestWorkersSettleTheirOwnDeliveries(t *testing.T) {
+	a := newTestApp(t, time.Second)
+	a.dbUp.Store(true)
+
+	acks := []*acknowledger{{}, {}}
+	for i, w := range a.workers {
+		msgs := make(chan amqp.Delivery, 3)
+		for n := 0; n <= i; n++ {
+			// Bodies that aren't requests fail without a video, and can't be dead-lettered without a channel
+			msgs <- amqp.Delivery{Acknowledger: acks[i], Body: []byte("not json")}
+		}
+		close(msgs)
+
+		assert.NoError(t, w.serve(context.Background(), msgs))
+	}
+
+	// Each delivery is settled by the worker that took it, and only once
+	assert.Equal(t, 1, acks[0].nacked)
+	assert.Equal(t, 2, acks[1].nacked)
+	assert.Equal(t, 0, acks[0].acked+acks[1].acked)
+}
+
+func TestStopFinishesTheJobAndHandsBackTheRest(t *testing.T) {
+	a := newTestApp(t, time.Second)
+	a.dbUp.Store(true)
+	w := a.workers[0]
+
+	inFlight := &blockingAcknowledger{started: make(chan struct{}), release: make(chan struct{})}
+	prefetched := &acknowledger{}
+
+	msgs := make(chan amqp.Delivery, 3)
+	msgs <- amqp.Delivery{Acknowledger: inFlight, Body: []byte("not json")}
+	msgs <- amqp.Delivery{Acknowledger: prefetched, Body: []byte("not json")}
+	msgs <- amqp.Delivery{Acknowledger: prefetched, Body: []byte("not json")}
+
+	served := make(chan error)
+	go func() { served <- w.serve(context.Background(), msgs) }()
+
+	<-inFlight.started
+	w.stop()
+	close(msgs)
+	close(inFlight.release)
+
+	assert.NoError(t, <-served)
+
+	// The job that had started is settled as usual, the ones waiting behind it go back on the queue untouched
+	assert.Equal(t, 1, inFlight.nacked)
+	assert.Equal(t, 2, prefetched.nacked)
+	assert.True(t, prefetched.requeue)
+	assert.Equal(t, 0, prefetched.acked)
+}
+
+func TestCallbacksOnlyGiveTheStatus(t *testing.T) {
+	db := &fakeDB{}
+	a := newTestApp(t, time.Second)
+	a.DB = db.open()
+	a.Webhooks = webhook.NewOutbox(a.DB, webhook.NewSender("secret"))
+
+	e := events.Event{Type: events.TypeFailed, VideoID: "abc", Status: "error", Error: "ffmpeg -i /tmp/abc exited with 1"}
+	a.workers[0].enqueueCallback(context.Background(), e, "https://93.184.216.34/hook")
+
+	args := db.Args()
+	assert.Len(t, args, 1)
+	body := string(args[0][3].([]byte))
+	assert.Contains(t, body, `"status":"error"`)
+	assert.NotContains(t, body, "ffmpeg")
+	assert.NotContains(t, body, `"error":`)
+}
+
+func TestHandleSkipsDuplicatesUnlessReprocessing(t *testing.T) {
+	db := &fakeDB{status: "transcoded"}
+	client := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	a := &App{TmpDir: t.TempDir(), DB: db.open(), Storage: storage.NewMemory(), Steps: steps.New(client, "bow")}
+	a.Profiles = profile.Default("processed", "small")
+	a.Events = events.NewPublisher(func() *amqp.Connection { return nil })
+	a.dbUp.Store(true)
+	w, err := a.newWorker(0)
+	assert.NoError(t, err)
+
+	body := []byte(`{"id":"abc","url":"https://takeabow.s3.amazonaws.com/upload/abc.mp4"}`)
+
+	// A header doesn't make an upload a reprocess, so a transcoded video's upload is a duplicate
+	ack := &acknowledger{}
+	w.handle(context.Background(), amqp.Delivery{Acknowledger: ack, ConsumerTag: w.tag, Headers: amqp.Table{"x-reprocess": true}, Body: body})
+	assert.Equal(t, 1, ack.acked)
+	assert.Empty(t, db.Execs())
+
+	// From the reprocess queue it's processed again
+	ack = &acknowledger{}
+	w.handle(context.Background(), amqp.Delivery{Acknowledger: ack, ConsumerTag: w.reprocessTag, Body: body})
+	assert.Equal(t, 0, ack.acked)
+	assert.NotEmpty(t, db.Execs())
+}
+
+func TestMergeClosesOnceBothConsumersAre(t *testing.T) {
+	uploads := make(chan amqp.Delivery, 2)
+	reprocess := make(chan amqp.Delivery, 1)
+	uploads <- amqp.Delivery{MessageId: "1"}
+	uploads <- amqp.Delivery{MessageId: "2"}
+	reprocess <- amqp.Delivery{MessageId: "3"}
+	close(uploads)
+
+	msgs := merge(uploads, reprocess)
+	ids := map[string]bool{}
+	for i := 0; i < 3; i++ {
+		ids[(<-msgs).MessageId] = true
+	}
+	assert.Len(t, ids, 3)
+
+	// The reprocess consumer is still going
+	select {
+	case <-msgs:
+		t.Fatal("Closed before both consumers were")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	close(reprocess)
+	_, ok := <-msgs
+	assert.False(t, ok)
+}
@@ -0,0 +1,269 @@
+package command
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/therealpenguin/takeabow-upload-processor/logging"
+	"io"
+	"net/url"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Config says where the binaries we shell out to are and how long they may run for
+type Config struct {
+	FFmpeg       string
+	FFprobe      string
+	YoutubeDL    string
+	Timeout      time.Duration
+	ProbeTimeout time.Duration
+}
+
+// DefaultConfig points at where the Alpine packages install the binaries
+var DefaultConfig = Config{
+	FFmpeg:       "/usr/bin/ffmpeg",
+	FFprobe:      "/usr/bin/ffprobe",
+	YoutubeDL:    "/usr/bin/youtube-dl",
+	Timeout:      2 * time.Hour,
+	ProbeTimeout: time.Minute,
+}
+
+var (
+	mu     sync.RWMutex
+	config = DefaultConfig
+)
+
+// Configure replaces the binary paths and timeouts. Empty fields keep their defaults
+func Configure(c Config) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if c.FFmpeg == "" {
+		c.FFmpeg = DefaultConfig.FFmpeg
+	}
+	if c.FFprobe == "" {
+		c.FFprobe = DefaultConfig.FFprobe
+	}
+	if c.YoutubeDL == "" {
+		c.YoutubeDL = DefaultConfig.YoutubeDL
+	}
+	if c.Timeout == 0 {
+		c.Timeout = DefaultConfig.Timeout
+	}
+	if c.ProbeTimeout == 0 {
+		c.ProbeTimeout = DefaultConfig.ProbeTimeout
+	}
+
+	config = c
+}
+
+func current() Config {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	return config
+}
+
+// WaitDelay is how long to wait for a killed command's output to close before giving up on it
+const WaitDelay = 5 * time.Second
+
+// MaxStderr is how much of the end of a command's stderr is kept for its error
+const MaxStderr = 64 << 10
+
+// Command is a binary and the exact arguments it will be run with. Arguments are never split or interpreted by a shell
+type Command struct {
+	Path    string
+	Args    []string
+	Timeout time.Duration
+	Stdout  io.Writer
+	Stderr  io.Writer
+}
+
+// New creates a command that runs the binary at path with no timeout
+func New(path string, args ...string) *Command {
+	return &Command{
+		Path: path,
+		Args: args,
+	}
+}
+
+// FFmpeg creates an ffmpeg command that never prompts and is killed after the configured timeout
+func FFmpeg(args ...string) *Command {
+	c := current()
+	return New(c.FFmpeg, append([]string{"-nostdin", "-y"}, args...)...).WithTimeout(c.Timeout)
+}
+
+// FFprobe creates an ffprobe command that is killed after the configured probe timeout
+func FFprobe(args ...string) *Command {
+	c := current()
+	return New(c.FFprobe, args...).WithTimeout(c.ProbeTimeout)
+}
+
+// YoutubeDL creates a youtube-dl command for a URL. The URL goes after "--" so it can never be read as an option
+func YoutubeDL(rawurl string, args ...string) (*Command, error) {
+	u, err := URL(rawurl)
+	if err != nil {
+		return nil, err
+	}
+
+	c := current()
+	args = append(args, "--", u)
+	return New(c.YoutubeDL, args...).WithTimeout(c.Timeout), nil
+}
+
+// Arg appends arguments to the command
+func (c *Command) Arg(args ...string) *Command {
+	c.Args = append(c.Args, args...)
+	return c
+}
+
+// WithTimeout kills the command if it runs for longer than d. Zero means no timeout
+func (c *Command) WithTimeout(d time.Duration) *Command {
+	c.Timeout = d
+	return c
+}
+
+// WithStdout streams the command's stdout to w as it runs, instead of returning it from Run
+func (c *Command) WithStdout(w io.Writer) *Command {
+	c.Stdout = w
+	return c
+}
+
+// WithStderr copies the command's stderr to w as it runs, as well as keeping it for the error
+func (c *Command) WithStderr(w io.Writer) *Command {
+	c.Stderr = w
+	return c
+}
+
+// String gets the command line, for logging
+func (c *Command) String() string {
+	return strings.Join(append([]string{c.Path}, c.Args...), " ")
+}
+
+// Error is returned when a command can't be started or exits unsuccessfully
+type Error struct {
+	Command  string
+	ExitCode int
+	Stderr   string
+	Err      error
+}
+
+func (e *Error) Error() string {
+	if e.Stderr == "" {
+		return fmt.Sprintf("%s: %s", e.Command, e.Err)
+	}
+
+	return fmt.Sprintf("%s: %s: %s", e.Command, e.Err, Tail(e.Stderr, 5))
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Run runs the command and gets its stdout. The last MaxStderr bytes of stderr are kept separately, on the error if it fails.
+// Cancelling ctx or passing the timeout kills the process
+func (c *Command) Run(ctx context.Context) ([]byte, error) {
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+
+	var stdout bytes.Buffer
+	var stderr tailBuffer
+	cmd := exec.CommandContext(ctx, c.Path, c.Args...)
+	cmd.Stdout = &stdout
+	if c.Stdout != nil {
+		cmd.Stdout = c.Stdout
+	}
+	cmd.Stderr = &stderr
+	if c.Stderr != nil {
+		cmd.Stderr = io.MultiWriter(&stderr, c.Stderr)
+	}
+	cmd.WaitDelay = WaitDelay
+	killGroup(cmd)
+
+	err := cmd.Run()
+	if err != nil {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+
+		cerr := &Error{
+			Command:  c.String(),
+			ExitCode: -1,
+			Stderr:   stderr.String(),
+			Err:      err,
+		}
+
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			cerr.ExitCode = exitErr.ExitCode()
+		}
+
+		logging.From(ctx).Error("Command failed", "command", c.String(), "exit_code", cerr.ExitCode,
+			logging.Err(err), logging.KeyStderr, Tail(cerr.Stderr, 5))
+		return stdout.Bytes(), cerr
+	}
+
+	return stdout.Bytes(), nil
+}
+
+// tailBuffer keeps the last MaxStderr bytes written to it
+type tailBuffer struct {
+	data []byte
+}
+
+func (t *tailBuffer) Write(p []byte) (int, error) {
+	n := len(p)
+	if n >= MaxStderr {
+		t.data = append(t.data[:0], p[n-MaxStderr:]...)
+		return n, nil
+	}
+
+	if over := len(t.data) + n - MaxStderr; over > 0 {
+		t.data = append(t.data[:0], t.data[over:]...)
+	}
+	t.data = append(t.data, p...)
+	return n, nil
+}
+
+func (t *tailBuffer) String() string {
+	return string(t.data)
+}
+
+// URL checks a URL is an absolute http or https URL before it is handed to a command
+func URL(rawurl string) (string, error) {
+	if strings.HasPrefix(rawurl, "-") {
+		return "", fmt.Errorf("URL %q looks like an option", rawurl)
+	}
+
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return "", err
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", fmt.Errorf("URL %q must be http or https", rawurl)
+	}
+
+	if u.Host == "" {
+		return "", fmt.Errorf("URL %q has no host", rawurl)
+	}
+
+	return rawurl, nil
+}
+
+// Tail gets the last n lines of output, which is where ffmpeg and youtube-dl put the reason they failed
+func Tail(output string, n int) string {
+	lines := strings.Split(strings.TrimRight(output, "\n"), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,78 @@
+package command
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestYoutubeDLHostileURLs(t *testing.T) {
+	type TestCase struct {
+		Url   string
+		Valid bool
+	}
+
+	testcases := []TestCase{
+		{Url: "https://www.youtube.com/watch?v=-wtIMTCHWuI", Valid: true},
+		{Url: "https://www.youtube.com/watch?v=x --exec rm${IFS}-rf${IFS}/", Valid: true},
+		{Url: "https://vimeo.com/1 -o /etc/cron.d/evil", Valid: true},
+		{Url: "https://www.youtube.com/watch?v=x;reboot", Valid: true},
+		{Url: "--exec=reboot", Valid: false},
+		{Url: "-o/etc/passwd", Valid: false},
+		{Url: "file:///etc/passwd", Valid: false},
+		{Url: "https://", Valid: false},
+		{Url: "youtube.com/watch?v=x", Valid: false},
+	}
+
+	for _, tc := range testcases {
+		c, err := YoutubeDL(tc.Url, "-f", "mp4", "-s")
+		if !tc.Valid {
+			assert.NotNil(t, err, tc.Url)
+			continue
+		}
+
+		assert.Nil(t, err, tc.Url)
+		// The URL is one argument, after the end of the options
+		assert.Equal(t, []string{"-f", "mp4", "-s", "--", tc.Url}, c.Args)
+	}
+}
+
+func TestRunSeparatesStderr(t *testing.T) {
+	out, err := New("/bin/sh", "-c", "echo out; echo err >&2").Run(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, "out\n", string(out))
+
+	_, err = New("/bin/sh", "-c", "echo out; echo first >&2; echo reason >&2; exit 3").Run(context.Background())
+	var cerr *Error
+	assert.True(t, errors.As(err, &cerr))
+	assert.Equal(t, 3, cerr.ExitCode)
+	assert.Equal(t, "first\nreason\n", cerr.Stderr)
+}
+
+func TestRunKeepsTheEndOfStderr(t *testing.T) {
+	_, err := New("/bin/sh", "-c", "head -c 200000 /dev/zero | tr '\\0' x >&2; echo >&2; echo reason >&2; exit 1").Run(context.Background())
+	var cerr *Error
+	assert.True(t, errors.As(err, &cerr))
+	assert.Equal(t, MaxStderr, len(cerr.Stderr))
+	assert.Equal(t, "reason", Tail(cerr.Stderr, 1))
+}
+
+func TestRunTimeout(t *testing.T) {
+	start := time.Now()
+	_, err := New("/bin/sh", "-c", "sleep 5").WithTimeout(50 * time.Millisecond).Run(context.Background())
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	assert.True(t, time.Since(start) < 5*time.Second)
+}
+
+func TestArgsWithSpacesAreKept(t *testing.T) {
+	out, err := New("/bin/sh", "-c", `printf '%s|' "$@"`, "sh", "/tmp/my video.mp4", "a b").Run(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, "/tmp/my video.mp4|a b|", string(out))
+}
+
+func TestTail(t *testing.T) {
+	assert.Equal(t, "c\nd", Tail("a\nb\nc\nd\n", 2))
+	assert.Equal(t, "a", Tail("a", 2))
+}
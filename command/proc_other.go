@@ -0,0 +1,10 @@
+//go:build windows
+
+package command
+
+import (
+	"os/exec"
+)
+
+// killGroup leaves cmd alone, there are no process groups to kill here
+func killGroup(cmd *exec.Cmd) {}
@@ -0,0 +1,130 @@
+package confirm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/streadway/amqp"
+	"time"
+)
+
+// Timeout is how long to wait for the broker to confirm a message before giving up on it
+const Timeout = 10 * time.Second
+
+// ErrClosed means the channel closed before the broker confirmed a message
+var ErrClosed = errors.New("Channel closed before the message was confirmed")
+
+// ErrUnroutable means the broker took a message but had no queue to route it to
+var ErrUnroutable = errors.New("Broker couldn't route message")
+
+// Channel publishes on an AMQP channel in confirm mode, so a message is only taken as sent once the broker has it.
+// Messages are mandatory, so one the broker can't route anywhere is reported with ErrUnroutable.
+// It's only safe to publish from one goroutine at a time
+type Channel struct {
+	ch        *amqp.Channel
+	outcomes  chan outcome
+	published uint64
+}
+
+// outcome is how the broker answered a message: its confirm, and its return if it couldn't be routed
+type outcome struct {
+	amqp.Confirmation
+	returned *amqp.Return
+}
+
+// Open opens a channel on conn in confirm mode
+func Open(conn *amqp.Connection) (*Channel, error) {
+	ch, err := conn.Channel()
+	if err != nil {
+		return nil, err
+	}
+
+	err = ch.Confirm(false)
+	if err != nil {
+		ch.Close()
+		return nil, err
+	}
+
+	c := &Channel{
+		ch:       ch,
+		outcomes: make(chan outcome, 1),
+	}
+
+	// Unbuffered, so a return is always taken before the confirm the broker sends after it
+	go c.watch(ch.NotifyPublish(make(chan amqp.Confirmation)), ch.NotifyReturn(make(chan amqp.Return)))
+
+	return c, nil
+}
+
+// watch pairs each confirm with the return sent before it, if there was one. It always reads both, so an answer
+// that comes after its message timed out doesn't hold up the connection
+func (c *Channel) watch(confirms <-chan amqp.Confirmation, returns <-chan amqp.Return) {
+	defer close(c.outcomes)
+
+	var returned *amqp.Return
+	for {
+		select {
+		case ret, ok := <-returns:
+			if !ok {
+				returns = nil
+				continue
+			}
+			returned = &ret
+		case conf, ok := <-confirms:
+			if !ok {
+				return
+			}
+
+			// Replace an answer nobody took, it was for a message that timed out
+			select {
+			case <-c.outcomes:
+			default:
+			}
+			c.outcomes <- outcome{conf, returned}
+			returned = nil
+		}
+	}
+}
+
+// Publish sends msg and waits until the broker confirms it, ctx is cancelled or Timeout passes.
+// Answers for earlier messages that came too late are skipped
+func (c *Channel) Publish(ctx context.Context, exchange, key string, msg amqp.Publishing) error {
+	err := c.ch.Publish(exchange, key, true, false, msg)
+	if err != nil {
+		return err
+	}
+	c.published++
+
+	timeout := time.After(Timeout)
+	for {
+		select {
+		case o, ok := <-c.outcomes:
+			if !ok {
+				return ErrClosed
+			}
+
+			if o.DeliveryTag < c.published {
+				continue
+			}
+
+			if !o.Ack {
+				return fmt.Errorf("Broker refused message to %q with key %q", exchange, key)
+			}
+
+			if o.returned != nil {
+				return fmt.Errorf("%w to %q with key %q: %s", ErrUnroutable, exchange, key, o.returned.ReplyText)
+			}
+
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timeout:
+			return fmt.Errorf("Message to %q with key %q wasn't confirmed within %s", exchange, key, Timeout)
+		}
+	}
+}
+
+// Close closes the channel
+func (c *Channel) Close() error {
+	return c.ch.Close()
+}
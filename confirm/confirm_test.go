@@ -0,0 +1,48 @@
+package confirm
+
+import (
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestWatchPairsReturnsWithTheirConfirms(t *testing.T) {
+	c := &Channel{outcomes: make(chan outcome, 1)}
+	confirms := make(chan amqp.Confirmation)
+	returns := make(chan amqp.Return)
+	go c.watch(confirms, returns)
+
+	returns <- amqp.Return{ReplyText: "NO_ROUTE"}
+	confirms <- amqp.Confirmation{DeliveryTag: 1, Ack: true}
+	o := <-c.outcomes
+	assert.Equal(t, uint64(1), o.DeliveryTag)
+	assert.Equal(t, "NO_ROUTE", o.returned.ReplyText)
+
+	// Answers nobody takes, such as one that came after its message timed out, don't hold up the connection
+	sent := make(chan struct{})
+	go func() {
+		returns <- amqp.Return{ReplyText: "NO_ROUTE"}
+		for tag := uint64(2); tag <= 4; tag++ {
+			confirms <- amqp.Confirmation{DeliveryTag: tag, Ack: true}
+		}
+		close(sent)
+	}()
+
+	select {
+	case <-sent:
+	case <-time.After(time.Second):
+		t.Fatal("Answers blocked")
+	}
+
+	// The last answer is the one waiting, without the return that came before the first
+	for o = <-c.outcomes; o.DeliveryTag < 4; o = <-c.outcomes {
+	}
+	assert.Nil(t, o.returned)
+
+	// The channel closing closes the outcomes
+	close(returns)
+	close(confirms)
+	_, ok := <-c.outcomes
+	assert.False(t, ok)
+}
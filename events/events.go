@@ -0,0 +1,62 @@
+package events
+
+import (
+	"github.com/therealpenguin/takeabow-upload-processor/profile"
+	"github.com/therealpenguin/takeabow-upload-processor/video"
+	"time"
+)
+
+// ExchangeEvents is the topic exchange events are published to, routed by their type
+const ExchangeEvents = "upload.events"
+
+const TypeTranscoded = "video.transcoded"
+const TypeFailed = "video.failed"
+const TypeRejected = "video.rejected"
+
+// Keys are the objects made from a video
+type Keys struct {
+	Processed  string            `json:"processed,omitempty"`
+	Small      string            `json:"small,omitempty"`
+	Renditions map[string]string `json:"renditions,omitempty"`
+	Slots      []video.Split     `json:"slots,omitempty"`
+	Poster     string            `json:"poster,omitempty"`
+	Thumbnails []string          `json:"thumbnails,omitempty"`
+}
+
+// Event tells anyone listening how processing a video ended
+type Event struct {
+	Type     string `json:"type"`
+	VideoID  string `json:"id"`
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+	Duration int    `json:"duration"`
+	Timeline string `json:"timeline,omitempty"`
+	Keys     Keys   `json:"keys"`
+
+	Attempt        int       `json:"attempt"`
+	StartedAt      time.Time `json:"started_at"`
+	FinishedAt     time.Time `json:"finished_at"`
+	ElapsedSeconds float64   `json:"elapsed_seconds"`
+}
+
+// New creates an event for a video from what processing recorded on its request
+func New(typ string, r *video.VideoRequest, attempt int, started, finished time.Time) Event {
+	return Event{
+		Type:     typ,
+		VideoID:  r.Id,
+		Duration: r.Duration,
+		Timeline: r.Timeline,
+		Keys: Keys{
+			Processed:  r.Renditions[profile.NameProcessed],
+			Small:      r.Renditions[profile.NameSmall],
+			Renditions: r.Renditions,
+			Slots:      r.Splits,
+			Poster:     r.Poster,
+			Thumbnails: r.Thumbnails,
+		},
+		Attempt:        attempt,
+		StartedAt:      started,
+		FinishedAt:     finished,
+		ElapsedSeconds: finished.Sub(started).Seconds(),
+	}
+}
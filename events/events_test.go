@@ -0,0 +1,132 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+	"github.com/therealpenguin/takeabow-upload-processor/confirm"
+	"github.com/therealpenguin/takeabow-upload-processor/video"
+	"testing"
+	"time"
+)
+
+func TestNew(t *testing.T) {
+	r := &video.VideoRequest{
+		Id:       "abc",
+		Duration: 42,
+		Renditions: map[string]string{
+			"processed": "processed/abc.mp4",
+			"small":     "small/abc.mp4",
+		},
+		Splits: []video.Split{
+			{Timeline: "default", Slot: 2, Key: "split/default/2/abc.mp4", Start: 1.5, End: 4},
+		},
+	}
+
+	started := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	e := New(TypeTranscoded, r, 2, started, started.Add(90*time.Second))
+
+	assert.Equal(t, "processed/abc.mp4", e.Keys.Processed)
+	assert.Equal(t, "small/abc.mp4", e.Keys.Small)
+	assert.Equal(t, 90.0, e.ElapsedSeconds)
+
+	body, err := json.Marshal(e)
+	assert.NoError(t, err)
+
+	var got map[string]interface{}
+	assert.NoError(t, json.Unmarshal(body, &got))
+	assert.Equal(t, "abc", got["id"])
+	assert.Equal(t, "video.transcoded", got["type"])
+
+	slots := got["keys"].(map[string]interface{})["slots"].([]interface{})
+	assert.Equal(t, "split/default/2/abc.mp4", slots[0].(map[string]interface{})["key"])
+}
+
+// fakeChannel is a channel in confirm mode that answers every message with err
+type fakeChannel struct {
+	published []amqp.Publishing
+	keys      []string
+	err       error
+	closed    bool
+}
+
+func (f *fakeChannel) Publish(ctx context.Context, exchange, key string, msg amqp.Publishing) error {
+	f.published = append(f.published, msg)
+	f.keys = append(f.keys, exchange+" "+key)
+	return f.err
+}
+
+func (f *fakeChannel) Close() error {
+	f.closed = true
+	return nil
+}
+
+// newTestPublisher publishes on whatever connection *conn is, opening a new fakeChannel each time it needs one
+func newTestPublisher(conn **amqp.Connection) (*Publisher, *[]*fakeChannel) {
+	opened := &[]*fakeChannel{}
+	p := NewPublisher(func() *amqp.Connection { return *conn })
+	p.openChannel = func(*amqp.Connection) (channel, error) {
+		ch := &fakeChannel{}
+		*opened = append(*opened, ch)
+		return ch, nil
+	}
+
+	return p, opened
+}
+
+func TestPublish(t *testing.T) {
+	conn := &amqp.Connection{}
+	p, opened := newTestPublisher(&conn)
+
+	assert.NoError(t, p.Publish(context.Background(), Event{Type: TypeTranscoded, VideoID: "abc"}))
+	assert.NoError(t, p.Publish(context.Background(), Event{Type: TypeFailed, VideoID: "def"}))
+
+	// Both go on the one channel, routed by type
+	assert.Len(t, *opened, 1)
+	ch := (*opened)[0]
+	assert.Equal(t, []string{"upload.events video.transcoded", "upload.events video.failed"}, ch.keys)
+	assert.Equal(t, amqp.Persistent, ch.published[0].DeliveryMode)
+	assert.Contains(t, string(ch.published[0].Body), `"id":"abc"`)
+
+	// Without a connection there's nothing to publish on
+	conn = nil
+	assert.Error(t, p.Publish(context.Background(), Event{Type: TypeTranscoded, VideoID: "ghi"}))
+}
+
+func TestPublishReopensOnANewConnection(t *testing.T) {
+	conn := &amqp.Connection{}
+	p, opened := newTestPublisher(&conn)
+
+	assert.NoError(t, p.Publish(context.Background(), Event{Type: TypeTranscoded, VideoID: "abc"}))
+
+	conn = &amqp.Connection{}
+	assert.NoError(t, p.Publish(context.Background(), Event{Type: TypeTranscoded, VideoID: "def"}))
+
+	assert.Len(t, *opened, 2)
+	assert.True(t, (*opened)[0].closed)
+	assert.Len(t, (*opened)[1].published, 1)
+}
+
+func TestPublishUnroutable(t *testing.T) {
+	conn := &amqp.Connection{}
+	p, opened := newTestPublisher(&conn)
+
+	assert.NoError(t, p.Publish(context.Background(), Event{Type: TypeTranscoded, VideoID: "abc"}))
+	ch := (*opened)[0]
+
+	// Nobody listening for an event isn't a failure, and the channel carries on
+	ch.err = fmt.Errorf("%w to %q with key %q: NO_ROUTE", confirm.ErrUnroutable, ExchangeEvents, TypeRejected)
+	assert.NoError(t, p.Publish(context.Background(), Event{Type: TypeRejected, VideoID: "def"}))
+	assert.False(t, ch.closed)
+
+	// An event the broker didn't take is, and the next one goes on a new channel
+	ch.err = errors.New("Broker refused message")
+	assert.Error(t, p.Publish(context.Background(), Event{Type: TypeFailed, VideoID: "ghi"}))
+	assert.True(t, ch.closed)
+
+	assert.NoError(t, p.Publish(context.Background(), Event{Type: TypeFailed, VideoID: "ghi"}))
+	assert.Len(t, *opened, 2)
+}
@@ -0,0 +1,113 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/streadway/amqp"
+	"github.com/therealpenguin/takeabow-upload-processor/confirm"
+	"github.com/therealpenguin/takeabow-upload-processor/tracing"
+	"sync"
+)
+
+// Publisher publishes events on a channel of its own in confirm mode, so an event the broker didn't take is an error.
+// One the broker couldn't route to any queue isn't, as that only means nobody is listening for it
+type Publisher struct {
+	conn func() *amqp.Connection
+	// openChannel opens a channel in confirm mode on a connection
+	openChannel func(*amqp.Connection) (channel, error)
+	mu          sync.Mutex
+	ch          channel
+	// on is the connection ch was opened on
+	on *amqp.Connection
+}
+
+// channel is what events are published on, a confirm.Channel outside tests
+type channel interface {
+	Publish(ctx context.Context, exchange, key string, msg amqp.Publishing) error
+	Close() error
+}
+
+// NewPublisher publishes on whichever connection the function returns, so it keeps working after a reconnect
+func NewPublisher(conn func() *amqp.Connection) *Publisher {
+	return &Publisher{
+		conn: conn,
+		openChannel: func(conn *amqp.Connection) (channel, error) {
+			return confirm.Open(conn)
+		},
+	}
+}
+
+// Publish sends e and waits for the broker to confirm it
+func (p *Publisher) Publish(ctx context.Context, e Event) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	headers := amqp.Table{}
+	tracing.Inject(ctx, headers)
+
+	// Confirms come back in order, so only one event can be waiting on the channel at a time
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	err = p.open()
+	if err != nil {
+		return err
+	}
+
+	err = p.ch.Publish(ctx, ExchangeEvents, e.Type, amqp.Publishing{
+		Headers:      headers,
+		ContentType:  "application/json",
+		DeliveryMode: amqp.Persistent,
+		Timestamp:    e.FinishedAt,
+		Body:         body,
+	})
+	if errors.Is(err, confirm.ErrUnroutable) {
+		return nil
+	}
+	if err != nil {
+		// The channel may have gone with the error, so start again on a new one
+		p.reset()
+		return fmt.Errorf("Error publishing event %s for video %s: %w", e.Type, e.VideoID, err)
+	}
+
+	return nil
+}
+
+// open puts a new channel into confirm mode if there isn't one already on the current connection
+func (p *Publisher) open() error {
+	conn := p.conn()
+	if p.ch != nil && p.on == conn {
+		return nil
+	}
+
+	// The connection has been replaced since the channel was opened, so the channel is gone with the old one
+	p.reset()
+
+	if conn == nil || conn.IsClosed() {
+		return errors.New("No connection to publish events on")
+	}
+
+	ch, err := p.openChannel(conn)
+	if err != nil {
+		return err
+	}
+
+	p.ch = ch
+	p.on = conn
+
+	return nil
+}
+
+// reset drops the channel so the next event opens a new one
+func (p *Publisher) reset() {
+	if p.ch != nil {
+		p.ch.Close()
+	}
+
+	p.ch = nil
+	p.on = nil
+}
@@ -0,0 +1,74 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+const FormatJSON = "json"
+const FormatLogfmt = "logfmt"
+
+// The attributes lines about a video carry
+const KeyVideoID = "video_id"
+const KeySource = "source"
+const KeyStep = "step"
+const KeyAttempt = "attempt"
+const KeySlot = "slot"
+const KeyTimeline = "timeline"
+const KeyError = "error"
+const KeyStderr = "stderr"
+
+type contextKey struct{}
+
+// New creates a logger writing to w in format, one of FormatJSON or FormatLogfmt, at level, such as "info" or "debug"
+func New(w io.Writer, format, level string) (*slog.Logger, error) {
+	var l slog.Level
+	if level != "" {
+		err := l.UnmarshalText([]byte(level))
+		if err != nil {
+			return nil, fmt.Errorf("Unknown log level %q", level)
+		}
+	}
+
+	options := &slog.HandlerOptions{Level: l}
+
+	switch strings.ToLower(format) {
+	case "", FormatJSON:
+		return slog.New(slog.NewJSONHandler(w, options)), nil
+	case FormatLogfmt:
+		return slog.New(slog.NewTextHandler(w, options)), nil
+	}
+
+	return nil, fmt.Errorf("Unknown log format %q", format)
+}
+
+// WithLogger gets a context carrying logger
+func WithLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, logger)
+}
+
+// From gets the logger ctx carries, or the default logger
+func From(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(contextKey{}).(*slog.Logger); ok {
+		return logger
+	}
+
+	return slog.Default()
+}
+
+// With gets a context whose logger adds args to every line, like slog.Logger.With
+func With(ctx context.Context, args ...any) context.Context {
+	return WithLogger(ctx, From(ctx).With(args...))
+}
+
+// Err is the attribute for an error
+func Err(err error) slog.Attr {
+	if err == nil {
+		return slog.String(KeyError, "")
+	}
+
+	return slog.String(KeyError, err.Error())
+}
@@ -0,0 +1,43 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestContextLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := New(&buf, FormatJSON, "")
+	assert.Nil(t, err)
+
+	ctx := WithLogger(context.Background(), logger)
+	ctx = With(ctx, KeyVideoID, "123", KeySource, "youtube")
+	ctx = With(ctx, KeyStep, "download")
+	From(ctx).Info("Downloaded video", KeyAttempt, 2)
+
+	line := make(map[string]interface{})
+	assert.Nil(t, json.Unmarshal(buf.Bytes(), &line))
+	assert.Equal(t, "Downloaded video", line["msg"])
+	assert.Equal(t, "123", line[KeyVideoID])
+	assert.Equal(t, "youtube", line[KeySource])
+	assert.Equal(t, "download", line[KeyStep])
+	assert.Equal(t, 2.0, line[KeyAttempt])
+}
+
+func TestLogfmt(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := New(&buf, FormatLogfmt, "debug")
+	assert.Nil(t, err)
+
+	logger.Debug("Split video", KeySlot, 3)
+	assert.Contains(t, buf.String(), `msg="Split video" slot=3`)
+
+	_, err = New(&buf, "xml", "")
+	assert.NotNil(t, err)
+
+	_, err = New(&buf, FormatJSON, "loud")
+	assert.NotNil(t, err)
+}
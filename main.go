@@ -1,76 +1,146 @@
 package main // import "github.com/therealpenguin/takeabow-upload-processor"
 import (
+	"context"
 	"database/sql"
 	"errors"
+	"flag"
 	"fmt"
 	_ "github.com/go-sql-driver/mysql"
-	"github.com/streadway/amqp"
 	"github.com/therealpenguin/takeabow-upload-processor/app"
-	"log"
+	"github.com/therealpenguin/takeabow-upload-processor/logging"
+	"github.com/therealpenguin/takeabow-upload-processor/tracing"
+	"log/slog"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 )
 
 func main() {
-	// Get config from environment
-	a, err := app.New()
-	failOnError(err, "Couldn't create app")
+	err := run()
+	if err != nil {
+		slog.Error("Exiting", logging.Err(err))
+		os.Exit(1)
+	}
+}
+
+// run does whatever the command line asks. It returns rather than exiting, so everything deferred gets done
+func run() error {
+	// Log JSON or logfmt lines, including those of anything still using the log package
+	logger, err := logging.New(os.Stderr, os.Getenv(app.EnvLogFormat), os.Getenv(app.EnvLogLevel))
+	if err != nil {
+		return fmt.Errorf("Couldn't create logger: %w", err)
+	}
+	slog.SetDefault(logger)
 
-	// Establish RabbitMQ connection to the uploads channel
-	conn, err := amqp.Dial(os.Getenv(app.EnvAMQPUrl))
+	// Export a trace of each upload, if we're told where to
+	shutdownTracing, err := tracing.Setup(context.Background(), os.Getenv(app.EnvTraceExporter), os.Getenv(app.EnvOTLPEndpoint))
+	if err != nil {
+		return fmt.Errorf("Couldn't set up tracing: %w", err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		shutdownTracing(ctx)
+	}()
 
+	// Get config from environment
+	a, err := app.New()
 	if err != nil {
-		failOnError(err, "Failed to connect to RabbitMQ")
+		return fmt.Errorf("Couldn't create app: %w", err)
+	}
+
+	// Rename the slot sets of earlier versions, then exit
+	if len(os.Args) > 1 && os.Args[1] == "migrate-slots" {
+		err = a.MigrateSlots()
+		if err != nil {
+			return fmt.Errorf("Couldn't migrate slots: %w", err)
+		}
+		return nil
 	}
-	defer conn.Close()
-
-	ch, err := conn.Channel()
-
-	_, err = ch.QueueDeclare(
-		app.ChannelUploads, // name
-		true,               // durable
-		false,              // delete when unused
-		false,              // exclusive
-		false,              // no-wait
-		nil,                // arguments
-	)
-	failOnError(err, "Failed to declare the uploads queue")
-
-	err = ch.Qos(
-		1,     // prefetch count
-		0,     // prefetch size
-		false, // global
-	)
-	failOnError(err, "Failed to set QoS")
-	a.Ch = ch
 
 	// Establish connection to database
-	dsn := os.Getenv(app.EnvMYSQLDsn)
+	dsn := a.MySQLDSN
 	if dsn == "" {
-		err = errors.New(fmt.Sprintf(app.TemplateEmpty, app.EnvMYSQLDsn))
-		failOnError(err, "Couldn't connect to MySQL")
+		return errors.New(fmt.Sprintf("Couldn't connect to MySQL: "+app.TemplateEmpty, app.EnvMYSQLDsn))
 	}
 
 	db, err := sql.Open("mysql", dsn)
 	if err != nil {
-		failOnError(err, "Couldn't connect to MySQL")
+		return fmt.Errorf("Couldn't connect to MySQL: %w", err)
 	}
 	defer db.Close()
 
-	if err = db.Ping(); err != nil {
-		failOnError(err, "Couldn't connect to MySQL")
+	// The app connects to RabbitMQ itself, and waits for MySQL to answer, reconnecting whenever either drops
+	a.DB = db
+
+	// Stop consuming on SIGTERM or SIGINT, giving the in-flight jobs a chance to finish
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
+	// Process existing videos again, then exit
+	if len(os.Args) > 1 && os.Args[1] == "reprocess" {
+		err = reprocess(ctx, a, os.Args[2:])
+		if err != nil {
+			return fmt.Errorf("Couldn't reprocess videos: %w", err)
+		}
+		return nil
 	}
 
-	a.DB = db
+	return a.Run(ctx)
+}
 
-	err = a.Run()
-	if err != nil {
-		failOnError(err, "Error")
+// reprocess reads the reprocess subcommand's flags and runs it
+func reprocess(ctx context.Context, a *app.App, args []string) error {
+	flags := flag.NewFlagSet("reprocess", flag.ExitOnError)
+	statuses := flags.String("status", "", "Only videos with one of these comma separated statuses")
+	since := flags.String("since", "", "Only videos created on or after this date, as 2006-01-02")
+	until := flags.String("until", "", "Only videos created before this date, as 2006-01-02")
+	ids := flags.String("ids", "", "Only the videos with these comma separated ids")
+	inline := flags.Bool("inline", false, "Process the videos here rather than publishing them onto the reprocess queue")
+	dryRun := flags.Bool("dry-run", false, "Only log which videos would be reprocessed")
+	concurrency := flags.Int("concurrency", 1, "How many videos to process or publish at once")
+	checkpoint := flags.String("checkpoint", "", "File of videos already done, which is added to as they finish so the run can be resumed")
+	force := flags.Bool("force", false, "Get videos from their original source, rather than the rendition we already have")
+	flags.Parse(args)
+
+	o := app.ReprocessOptions{
+		Statuses:    list(*statuses),
+		IDs:         list(*ids),
+		Inline:      *inline,
+		DryRun:      *dryRun,
+		Concurrency: *concurrency,
+		Checkpoint:  *checkpoint,
+		Force:       *force,
 	}
+
+	var err error
+	if *since != "" {
+		o.Since, err = time.Parse("2006-01-02", *since)
+		if err != nil {
+			return err
+		}
+	}
+
+	if *until != "" {
+		o.Until, err = time.Parse("2006-01-02", *until)
+		if err != nil {
+			return err
+		}
+	}
+
+	return a.Reprocess(ctx, o)
 }
 
-func failOnError(err error, msg string) {
-	if err != nil {
-		log.Fatalf("%s: %s", msg, err)
-		panic(fmt.Sprintf("%s: %s", msg, err))
+// list splits a comma separated flag, ignoring empty items
+func list(s string) []string {
+	items := make([]string, 0)
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
 	}
+
+	return items
 }
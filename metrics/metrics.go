@@ -0,0 +1,148 @@
+package metrics
+
+import (
+	"errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/therealpenguin/takeabow-upload-processor/command"
+	"io"
+	"strconv"
+	"time"
+)
+
+const namespace = "bow"
+
+const StepDownload = "download"
+const StepTranscode = "transcode"
+const StepSplit = "split"
+const StepUpload = "upload"
+const StepThumbnails = "thumbnails"
+
+// Processed counts videos processed successfully, by where they came from
+var Processed = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "videos_processed_total",
+	Help:      "Videos processed successfully.",
+}, []string{"source"})
+
+// Failed counts videos that failed for good, by where they came from
+var Failed = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "videos_failed_total",
+	Help:      "Videos that failed and won't be retried.",
+}, []string{"source"})
+
+// Rejected counts videos that broke a validation rule, by where they came from and the status they were given
+var Rejected = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "videos_rejected_total",
+	Help:      "Videos rejected by a validation rule.",
+}, []string{"source", "status"})
+
+// Retried counts attempts that failed and were scheduled to be tried again
+var Retried = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "videos_retried_total",
+	Help:      "Failed attempts that were scheduled to be retried.",
+}, []string{"source"})
+
+// InFlight is how many videos are being processed
+var InFlight = promauto.NewGauge(prometheus.GaugeOpts{
+	Namespace: namespace,
+	Name:      "videos_in_flight",
+	Help:      "Videos being processed.",
+})
+
+// EventsFailed counts events that the broker didn't confirm, by type
+var EventsFailed = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "events_failed_total",
+	Help:      "Completion events that weren't confirmed by the broker.",
+}, []string{"type"})
+
+// Webhooks counts attempts to deliver callbacks, by whether they were delivered, will be retried or were given up on
+var Webhooks = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "webhook_attempts_total",
+	Help:      "Attempts to deliver webhook callbacks, by outcome.",
+}, []string{"outcome"})
+
+// Reconnects counts how many times the connection to RabbitMQ was lost and dialled again
+var Reconnects = promauto.NewCounter(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "amqp_reconnects_total",
+	Help:      "Times the connection to RabbitMQ was lost and dialled again.",
+})
+
+// StepDuration is how long each step of processing takes. Transcodes are labelled with the profile they render
+var StepDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: namespace,
+	Name:      "step_duration_seconds",
+	Help:      "How long each step of processing a video takes.",
+	Buckets:   prometheus.ExponentialBuckets(0.5, 2, 14),
+}, []string{"step", "profile"})
+
+// BytesDownloaded counts the bytes of the videos downloaded
+var BytesDownloaded = promauto.NewCounter(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "downloaded_bytes_total",
+	Help:      "Bytes of video downloaded.",
+})
+
+// BytesUploaded counts the bytes uploaded to storage
+var BytesUploaded = promauto.NewCounter(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "uploaded_bytes_total",
+	Help:      "Bytes uploaded to storage.",
+})
+
+// SlotClips is how many clips each slot of each timeline has to choose from
+var SlotClips = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: namespace,
+	Name:      "slot_clips",
+	Help:      "Clips in each slot of each timeline.",
+}, []string{"timeline", "slot"})
+
+// FFmpegExits counts how ffmpeg exited. Runs that were killed or couldn't start are labelled -1
+var FFmpegExits = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "ffmpeg_exits_total",
+	Help:      "ffmpeg runs by exit code.",
+}, []string{"code"})
+
+// Time observes how long a step has taken since start
+func Time(step, profile string, start time.Time) {
+	StepDuration.WithLabelValues(step, profile).Observe(time.Since(start).Seconds())
+}
+
+// FFmpegExit counts the exit code of an ffmpeg run that returned err
+func FFmpegExit(err error) {
+	FFmpegExits.WithLabelValues(strconv.Itoa(ExitCode(err))).Inc()
+}
+
+// ExitCode gets the exit code of a command that returned err: 0 for nil, and -1 if it didn't exit by itself
+func ExitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+
+	var e *command.Error
+	if errors.As(err, &e) {
+		return e.ExitCode
+	}
+
+	return -1
+}
+
+// CountingReader counts the bytes read through it onto a counter
+type CountingReader struct {
+	io.Reader
+	Counter prometheus.Counter
+}
+
+func (c CountingReader) Read(p []byte) (int, error) {
+	n, err := c.Reader.Read(p)
+	c.Counter.Add(float64(n))
+
+	return n, err
+}
@@ -0,0 +1,27 @@
+package metrics
+
+import (
+	"errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/therealpenguin/takeabow-upload-processor/command"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestExitCode(t *testing.T) {
+	assert.Equal(t, 0, ExitCode(nil))
+	assert.Equal(t, 1, ExitCode(&command.Error{ExitCode: 1}))
+	assert.Equal(t, -1, ExitCode(errors.New("killed")))
+}
+
+func TestCountingReader(t *testing.T) {
+	counter := prometheus.NewCounter(prometheus.CounterOpts{Name: "test_bytes_total"})
+
+	_, err := ioutil.ReadAll(CountingReader{strings.NewReader("video"), counter})
+
+	assert.Nil(t, err)
+	assert.Equal(t, 5.0, testutil.ToFloat64(counter))
+}
@@ -0,0 +1,265 @@
+package probe
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/therealpenguin/takeabow-upload-processor/command"
+	"strconv"
+	"strings"
+)
+
+// ErrUnreadable means ffprobe couldn't make sense of the file
+var ErrUnreadable = errors.New("Media is unreadable")
+
+// ErrNoStreams means the file has no audio or video streams
+var ErrNoStreams = errors.New("Media has no streams")
+
+// ErrNoDuration means the file doesn't say how long it is
+var ErrNoDuration = errors.New("Media has no duration")
+
+// Error says which file couldn't be probed and why. It matches ErrUnreadable, ErrNoStreams or ErrNoDuration with errors.Is
+type Error struct {
+	Path  string
+	Kind  error
+	Cause error
+}
+
+func (e *Error) Error() string {
+	if e.Cause == nil {
+		return fmt.Sprintf("%s: %s", e.Path, e.Kind)
+	}
+
+	return fmt.Sprintf("%s: %s: %s", e.Path, e.Kind, e.Cause)
+}
+
+func (e *Error) Is(target error) bool {
+	return target == e.Kind
+}
+
+func (e *Error) Unwrap() error {
+	return e.Cause
+}
+
+const StreamVideo = "video"
+const StreamAudio = "audio"
+
+// Rational is a fraction, as ffprobe reports frame rates
+type Rational struct {
+	Num int64
+	Den int64
+}
+
+// ParseRational parses "30000/1001" or "25"
+func ParseRational(s string) (Rational, error) {
+	parts := strings.SplitN(s, "/", 2)
+	num, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return Rational{}, err
+	}
+
+	if len(parts) == 1 {
+		return Rational{num, 1}, nil
+	}
+
+	den, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return Rational{}, err
+	}
+
+	return Rational{num, den}, nil
+}
+
+// Valid reports whether the fraction is a usable, positive number. ffprobe reports unknown rates as 0/0
+func (r Rational) Valid() bool {
+	return r.Num > 0 && r.Den > 0
+}
+
+// Float gets the fraction's value, or 0 if it isn't valid
+func (r Rational) Float() float64 {
+	if !r.Valid() {
+		return 0
+	}
+
+	return float64(r.Num) / float64(r.Den)
+}
+
+func (r Rational) String() string {
+	return fmt.Sprintf("%d/%d", r.Num, r.Den)
+}
+
+// Stream is one audio or video stream in a file
+type Stream struct {
+	Index         int
+	Type          string
+	Codec         string
+	Width         int
+	Height        int
+	Rotation      int
+	AvgFrameRate  Rational
+	RealFrameRate Rational
+	Channels      int
+	BitRate       int64
+}
+
+// MediaInfo is what ffprobe tells us about a file
+type MediaInfo struct {
+	Container string
+	Duration  float64
+	BitRate   int64
+	Size      int64
+	Streams   []Stream
+}
+
+// Video gets the first video stream, or nil if there isn't one
+func (m *MediaInfo) Video() *Stream {
+	return m.stream(StreamVideo)
+}
+
+// Audio gets the first audio stream, or nil if there isn't one
+func (m *MediaInfo) Audio() *Stream {
+	return m.stream(StreamAudio)
+}
+
+func (m *MediaInfo) stream(kind string) *Stream {
+	for i := range m.Streams {
+		if m.Streams[i].Type == kind {
+			return &m.Streams[i]
+		}
+	}
+
+	return nil
+}
+
+// FrameRate gets the video's average frame rate, falling back to its real frame rate
+func (m *MediaInfo) FrameRate() (Rational, bool) {
+	v := m.Video()
+	if v == nil {
+		return Rational{}, false
+	}
+
+	if v.AvgFrameRate.Valid() {
+		return v.AvgFrameRate, true
+	}
+
+	if v.RealFrameRate.Valid() {
+		return v.RealFrameRate, true
+	}
+
+	return Rational{}, false
+}
+
+// Probe runs ffprobe once over path and gets everything we need to know about it
+func Probe(ctx context.Context, path string) (*MediaInfo, error) {
+	output, err := command.FFprobe(
+		"-v", "error",
+		"-print_format", "json",
+		"-show_format",
+		"-show_streams",
+		path,
+	).Run(ctx)
+
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, err
+		}
+
+		return nil, &Error{path, ErrUnreadable, err}
+	}
+
+	info, err := Parse(output)
+	if err != nil {
+		if perr, ok := err.(*Error); ok {
+			perr.Path = path
+		}
+		return nil, err
+	}
+
+	return info, nil
+}
+
+type output struct {
+	Format struct {
+		FormatName string `json:"format_name"`
+		Duration   string `json:"duration"`
+		BitRate    string `json:"bit_rate"`
+		Size       string `json:"size"`
+	} `json:"format"`
+	Streams []struct {
+		Index        int               `json:"index"`
+		CodecType    string            `json:"codec_type"`
+		CodecName    string            `json:"codec_name"`
+		Width        int               `json:"width"`
+		Height       int               `json:"height"`
+		AvgFrameRate string            `json:"avg_frame_rate"`
+		RFrameRate   string            `json:"r_frame_rate"`
+		Channels     int               `json:"channels"`
+		BitRate      string            `json:"bit_rate"`
+		Tags         map[string]string `json:"tags"`
+		SideDataList []struct {
+			Rotation *float64 `json:"rotation"`
+		} `json:"side_data_list"`
+	} `json:"streams"`
+}
+
+// Parse reads ffprobe's JSON output
+func Parse(data []byte) (*MediaInfo, error) {
+	var out output
+	err := json.Unmarshal(data, &out)
+	if err != nil {
+		return nil, &Error{Kind: ErrUnreadable, Cause: err}
+	}
+
+	if len(out.Streams) == 0 {
+		return nil, &Error{Kind: ErrNoStreams}
+	}
+
+	duration, err := strconv.ParseFloat(out.Format.Duration, 64)
+	if err != nil || duration <= 0 {
+		return nil, &Error{Kind: ErrNoDuration, Cause: err}
+	}
+
+	info := &MediaInfo{
+		Container: out.Format.FormatName,
+		Duration:  duration,
+		BitRate:   parseInt(out.Format.BitRate),
+		Size:      parseInt(out.Format.Size),
+	}
+
+	for _, s := range out.Streams {
+		stream := Stream{
+			Index:    s.Index,
+			Type:     s.CodecType,
+			Codec:    s.CodecName,
+			Width:    s.Width,
+			Height:   s.Height,
+			Channels: s.Channels,
+			BitRate:  parseInt(s.BitRate),
+		}
+
+		// Unknown rates stay as 0/0, which isn't Valid
+		stream.AvgFrameRate, _ = ParseRational(s.AvgFrameRate)
+		stream.RealFrameRate, _ = ParseRational(s.RFrameRate)
+
+		// Older ffprobes put rotation in a tag, newer ones in the display matrix
+		if rotate, ok := s.Tags["rotate"]; ok {
+			stream.Rotation, _ = strconv.Atoi(rotate)
+		}
+		for _, sd := range s.SideDataList {
+			if sd.Rotation != nil {
+				stream.Rotation = int(*sd.Rotation)
+			}
+		}
+
+		info.Streams = append(info.Streams, stream)
+	}
+
+	return info, nil
+}
+
+// parseInt parses the numbers ffprobe reports as strings, treating missing ones as 0
+func parseInt(s string) int64 {
+	i, _ := strconv.ParseInt(s, 10, 64)
+	return i
+}
@@ -0,0 +1,103 @@
+package probe
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+const phoneVideo = `{
+    "streams": [
+        {
+            "index": 0,
+            "codec_name": "h264",
+            "codec_type": "video",
+            "width": 1920,
+            "height": 1080,
+            "r_frame_rate": "30/1",
+            "avg_frame_rate": "30000/1001",
+            "bit_rate": "16000000",
+            "side_data_list": [
+                {
+                    "side_data_type": "Display Matrix",
+                    "rotation": -90
+                }
+            ]
+        },
+        {
+            "index": 1,
+            "codec_name": "aac",
+            "codec_type": "audio",
+            "r_frame_rate": "0/0",
+            "avg_frame_rate": "0/0",
+            "channels": 2,
+            "bit_rate": "128000"
+        }
+    ],
+    "format": {
+        "format_name": "mov,mp4,m4a,3gp,3g2,mj2",
+        "duration": "12.345000",
+        "size": "24690000",
+        "bit_rate": "16128000"
+    }
+}`
+
+func TestParse(t *testing.T) {
+	info, err := Parse([]byte(phoneVideo))
+	assert.Nil(t, err)
+
+	assert.Equal(t, "mov,mp4,m4a,3gp,3g2,mj2", info.Container)
+	assert.Equal(t, 12.345, info.Duration)
+	assert.Equal(t, int64(16128000), info.BitRate)
+	assert.Equal(t, int64(24690000), info.Size)
+
+	v := info.Video()
+	assert.NotNil(t, v)
+	assert.Equal(t, "h264", v.Codec)
+	assert.Equal(t, 1920, v.Width)
+	assert.Equal(t, 1080, v.Height)
+	assert.Equal(t, -90, v.Rotation)
+	assert.Equal(t, Rational{30000, 1001}, v.AvgFrameRate)
+	assert.Equal(t, Rational{30, 1}, v.RealFrameRate)
+
+	a := info.Audio()
+	assert.NotNil(t, a)
+	assert.Equal(t, 2, a.Channels)
+	assert.False(t, a.AvgFrameRate.Valid())
+
+	rate, ok := info.FrameRate()
+	assert.True(t, ok)
+	assert.Equal(t, "30000/1001", rate.String())
+	assert.InDelta(t, 29.97, rate.Float(), 0.01)
+}
+
+func TestParseErrors(t *testing.T) {
+	type TestCase struct {
+		Output string
+		Kind   error
+	}
+
+	testcases := []TestCase{
+		{Output: `not json`, Kind: ErrUnreadable},
+		{Output: `{"streams": [], "format": {"duration": "1.0"}}`, Kind: ErrNoStreams},
+		{Output: `{"streams": [{"codec_type": "audio"}], "format": {}}`, Kind: ErrNoDuration},
+	}
+
+	for _, tc := range testcases {
+		_, err := Parse([]byte(tc.Output))
+		assert.True(t, errors.Is(err, tc.Kind), tc.Output)
+	}
+}
+
+func TestParseRational(t *testing.T) {
+	r, err := ParseRational("25")
+	assert.Nil(t, err)
+	assert.Equal(t, 25.0, r.Float())
+
+	r, err = ParseRational("0/0")
+	assert.Nil(t, err)
+	assert.Equal(t, 0.0, r.Float())
+
+	_, err = ParseRational("abc")
+	assert.NotNil(t, err)
+}
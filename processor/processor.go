@@ -1,65 +1,104 @@
 package processor
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/therealpenguin/takeabow-upload-processor/command"
+	"github.com/therealpenguin/takeabow-upload-processor/logging"
+	"github.com/therealpenguin/takeabow-upload-processor/metrics"
+	"github.com/therealpenguin/takeabow-upload-processor/probe"
+	"github.com/therealpenguin/takeabow-upload-processor/profile"
+	"github.com/therealpenguin/takeabow-upload-processor/progress"
+	"github.com/therealpenguin/takeabow-upload-processor/retry"
+	"github.com/therealpenguin/takeabow-upload-processor/segment"
+	"github.com/therealpenguin/takeabow-upload-processor/slots"
+	"github.com/therealpenguin/takeabow-upload-processor/steps"
+	"github.com/therealpenguin/takeabow-upload-processor/storage"
 	"github.com/therealpenguin/takeabow-upload-processor/timecode"
+	"github.com/therealpenguin/takeabow-upload-processor/tracing"
+	"github.com/therealpenguin/takeabow-upload-processor/validate"
 	"github.com/therealpenguin/takeabow-upload-processor/video"
-	"gopkg.in/redis.v5"
+	"go.opentelemetry.io/otel/attribute"
 	"io"
-	"log"
 	"os"
-	"os/exec"
 	"strconv"
-	"strings"
+	"time"
 )
 
 type Processor struct {
-	sess        *session.Session
+	storage     storage.Backend
 	dir         string
-	bucket      string
-	prefix      string
-	smallPrefix string
+	profiles    []profile.Profile
+	rules       validate.Rules
+	progress    progress.Reporter
+	strategy    segment.Strategy
 	splitPrefix string
-	redis       *redis.Client
-	timecodes   *[]timecode.Timecode
+	slots       *slots.Registry
+	steps       *steps.Tracker
+	timelines   *timecode.Store
+	thumbs      Thumbnails
 }
 
-var VideoTooShort = errors.New("Video is too short")
+var VideoTooShort = segment.ErrTooShort
+
+// Config is what a Processor works with. Progress, Timelines and Thumbnails are optional: without them no progress
+// is reported, no slots are cut and no stills are taken. Strategy defaults to segment.Fixed
+type Config struct {
+	Storage storage.Backend
+	// Dir is where the video and everything made from it are kept while it's processed
+	Dir string
+	// Profiles are what the video is rendered to. The first is the primary one, which slots and thumbnails are cut from
+	Profiles    []profile.Profile
+	Rules       validate.Rules
+	SplitPrefix string
+	Slots       *slots.Registry
+	Steps       *steps.Tracker
+	Timelines   *timecode.Store
+	Thumbnails  Thumbnails
+	Progress    progress.Reporter
+	Strategy    segment.Strategy
+}
+
+// New creates a Processor from c
+func New(c Config) *Processor {
+	if c.Strategy == nil {
+		c.Strategy = segment.Fixed{}
+	}
 
-func New(sess *session.Session, dir, bucket, prefix, smallPrefix, splitPrefix string, redis *redis.Client, timecodes *[]timecode.Timecode) *Processor {
 	return &Processor{
-		sess:        sess,
-		dir:         dir,
-		bucket:      bucket,
-		prefix:      prefix,
-		smallPrefix: smallPrefix,
-		splitPrefix: splitPrefix,
-		redis:       redis,
-		timecodes:   timecodes,
+		storage:     c.Storage,
+		dir:         c.Dir,
+		profiles:    c.Profiles,
+		rules:       c.Rules,
+		splitPrefix: c.SplitPrefix,
+		slots:       c.Slots,
+		steps:       c.Steps,
+		timelines:   c.Timelines,
+		thumbs:      c.Thumbnails,
+		progress:    c.Progress,
+		strategy:    c.Strategy,
 	}
 }
 
 // Process gets the video into a file in a temporary directory, transcodes it into the format we want and uploads it to S3
-func (p *Processor) Process(v video.Video) error {
+// Cancelling ctx kills any running ffmpeg and removes the temporary files
+func (p *Processor) Process(ctx context.Context, v video.Video) error {
 	r := v.GetRequest()
-	fmt.Printf("Processing %s video\n%+v\n", r.GetSource(), r)
-	hasFile, err := v.HasVideo()
-	if err != nil {
-		return err
-	}
+	logging.From(ctx).Info("Processing video", "url", r.Url, "profile", r.Profile, logging.KeyTimeline, r.Timeline)
 
-	if !hasFile {
-		return errors.New(fmt.Sprintf("Video %s has no video", r.Url))
+	// Carry on from wherever an earlier attempt got to
+	done, err := p.steps.Load(r.Id)
+	if err != nil {
+		logging.From(ctx).Warn("Couldn't load the steps already done, starting again", logging.Err(err))
 	}
 
-	location, err := v.GetVideo(p.dir)
-
+	dctx, span := tracing.Start(ctx, "download", attribute.String("source", string(r.GetSource())))
+	location, err := p.download(dctx, v)
+	tracing.End(span, err)
 	if err != nil {
-		return errors.New(fmt.Sprintf("Error getting video %s: %s", r.Url, err.Error()))
+		return err
 	}
 
 	f, err := os.Open(location)
@@ -69,9 +108,36 @@ func (p *Processor) Process(v video.Video) error {
 	defer f.Close()
 	defer os.Remove(f.Name())
 
-	r.Duration = p.getDurationInSeconds(f.Name())
+	// Reject anything ffprobe can't read before spending time transcoding it
+	pctx, span := tracing.Start(ctx, "probe")
+	info, err := probe.Probe(pctx, f.Name())
+	tracing.End(span, err)
+	if err != nil {
+		if ctx.Err() != nil || errors.Is(err, context.DeadlineExceeded) {
+			return err
+		}
+		return retry.Permanent(fmt.Errorf("Error probing video %s: %w", r.Id, err))
+	}
 
-	err = p.processFile(f, r)
+	stat, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	err = p.rules.Check(info, stat.Size())
+	if err != nil {
+		return retry.Permanent(err)
+	}
+
+	r.Duration = int(info.Duration)
+
+	// A video reprocessed from its primary rendition already is one
+	var rendition string
+	if pv, ok := v.(*video.ProcessedVideo); ok {
+		rendition = pv.Rendition()
+	}
+
+	err = p.processFile(ctx, f, info, r, rendition, done)
 	if err != nil {
 		return err
 	}
@@ -79,224 +145,389 @@ func (p *Processor) Process(v video.Video) error {
 	return nil
 }
 
-func (p *Processor) getFramerate(f *os.File) (string, error) {
-	args := strings.Split(fmt.Sprintf("ffprobe -v error -select_streams v:0 -show_entries stream=avg_frame_rate -of default=noprint_wrappers=1:nokey=1 %s", f.Name()), " ")
-	cmd := exec.Command(args[0], args[1:]...)
-	output, err := runCommand(cmd)
+// download gets the video into a file. It's always downloaded again, as the file is removed once each attempt is over
+func (p *Processor) download(ctx context.Context, v video.Video) (string, error) {
+	r := v.GetRequest()
+	ctx = logging.With(ctx, logging.KeyStep, metrics.StepDownload)
+
+	hasFile, err := v.HasVideo(ctx)
 	if err != nil {
 		return "", err
 	}
-	framerate := strings.Replace(string(output), "\n", "", -1)
 
-	return framerate, nil
-}
+	if !hasFile {
+		return "", retry.Permanent(errors.New(fmt.Sprintf("Video %s has no video", r.Url)))
+	}
+
+	// Don't download a file we'd only reject for its size
+	if s, ok := v.(video.Sizer); ok && p.rules.MaxFileSize > 0 {
+		size, err := s.Size(ctx)
+		if err != nil {
+			logging.From(ctx).Warn("Couldn't get the video's size before downloading it", logging.Err(err))
+		} else if err = p.rules.CheckSize(size); err != nil {
+			return "", retry.Permanent(err)
+		}
+	}
+
+	start := time.Now()
+	location, err := v.GetVideo(ctx, p.dir)
 
-// processFile performs all the transcoding and uploading of a video file
-// It turns an input video into the same video format
-// It uploads the out of that onto S3
-// It splits that into slots and uploads that to S3
-// It processes that output video into a small video, ready for other tasks
-func (p *Processor) processFile(f *os.File, r *video.VideoRequest) error {
-	// Get the input framerate
-	framerate, err := p.getFramerate(f)
 	if err != nil {
-		framerate = "25"
+		return "", errors.New(fmt.Sprintf("Error getting video %s: %s", r.Url, err.Error()))
 	}
 
-	// Scale the video to 1080p, 16/9, 24fps, libx24, yuv420p and remove audio
-	command := "ffmpeg -y -r %s -i %s -filter:v scale=1920:1080,setdar=16/9 -r 24 -c:v libx264 -pix_fmt yuv420p -an %s"
-	destination := f.Name() + "-processed.mp4"
-	args := strings.Split(fmt.Sprintf(command, framerate, f.Name(), destination), " ")
+	metrics.Time(metrics.StepDownload, "", start)
+	if stat, err := os.Stat(location); err == nil {
+		metrics.BytesDownloaded.Add(float64(stat.Size()))
+	}
 
-	cmd := exec.Command(args[0], args[1:]...)
-	_, err = runCommand(cmd)
+	return location, nil
+}
 
+// mark records a step done for a video. Failing to is only logged, as it just means the step is done again if the video is retried
+func (p *Processor) mark(ctx context.Context, id, step, value string) {
+	err := p.steps.Mark(id, step, value)
 	if err != nil {
-		return err
+		logging.From(ctx).Warn("Couldn't record a step as done", "done", step, logging.Err(err))
 	}
+}
 
-	defer os.Remove(destination)
+// processFile performs all the transcoding and uploading of a video file
+// It renders the input video into each profile and uploads them
+// It splits the primary rendition into slots and uploads those
+// It takes thumbnails from the primary rendition and uploads those
+// Renditions, thumbnails and slots an earlier attempt uploaded, according to done, aren't made again.
+// If f is already the primary rendition, which is at the key rendition, it isn't rendered again
+func (p *Processor) processFile(ctx context.Context, f *os.File, info *probe.MediaInfo, r *video.VideoRequest, rendition string, done steps.Done) error {
+	// Get the input framerate
+	framerate := "25"
+	if rate, ok := info.FrameRate(); ok {
+		framerate = rate.String()
+	}
 
-	processed, err := os.Open(destination)
+	profiles, err := p.profilesFor(r)
 	if err != nil {
 		return err
 	}
 
-	key := fmt.Sprintf("%s/%s.mp4", p.prefix, r.Id)
+	job := progress.NewJob(r.Id, len(profiles), p.progress)
 
-	err = p.uploadFile(processed, key)
+	// A rendition is only reused for a reprocess if it was rendered with the primary profile as it is now
+	r.ProfileVersion = profiles[0].Version()
 
-	if err != nil {
-		return err
+	r.Renditions = make(map[string]string, len(profiles))
+
+	// Slots and thumbnails are cut from the primary rendition, so keep it if we need either
+	primary := p.timelines != nil || p.thumbs.Enabled()
+
+	var processed *os.File
+	for i, pr := range profiles {
+		destination := fmt.Sprintf("%s-%s.mp4", f.Name(), pr.Name)
+		ctx := logging.With(ctx, logging.KeyStep, metrics.StepTranscode, "profile", pr.Name)
+
+		// Remove the output even if ffmpeg is killed part way through
+		defer os.Remove(destination)
+
+		if i == 0 && rendition != "" {
+			logging.From(ctx).Info("Reprocessing from the primary rendition, so not rendering it again", "key", rendition)
+			r.Renditions[pr.Name] = rendition
+
+			if primary {
+				processed = f
+			}
+			continue
+		}
+
+		if key, ok := done.Has(steps.Rendered(pr.Name)); ok {
+			logging.From(ctx).Info("Already rendered by an earlier attempt", "key", key)
+			r.Renditions[pr.Name] = key
+
+			// Get back the primary rendition we uploaded
+			if i == 0 && primary {
+				err = p.downloadFile(ctx, key, destination)
+				if err != nil {
+					return err
+				}
+			}
+		} else {
+			tctx, span := tracing.Start(ctx, "transcode "+pr.Name, attribute.String("profile", pr.Name))
+			key, err := p.renderProfile(tctx, f, framerate, pr, destination, r.Id, job.Step(i, pr.Name, info.Duration))
+			tracing.End(span, err)
+			if err != nil {
+				return err
+			}
+
+			p.mark(ctx, r.Id, steps.Rendered(pr.Name), key)
+			r.Renditions[pr.Name] = key
+		}
+
+		if i == 0 && primary {
+			processed, err = os.Open(destination)
+			if err != nil {
+				return err
+			}
+
+			defer processed.Close()
+		}
 	}
 
-	log.Printf("Uploaded %s to s3://%s/%s", r.Id, p.bucket, key)
+	var analysis segment.Analysis
+	if processed != nil {
+		analysis = p.analyze(ctx, processed.Name(), info.Duration)
+	}
 
-	err = p.uploadSmallVideo(processed, r.Id)
+	if p.thumbs.Enabled() {
+		ctx := logging.With(ctx, logging.KeyStep, metrics.StepThumbnails)
+		keys := thumbnailKeys{}
+		if v, ok := done.Has(steps.StepThumbnails); ok && json.Unmarshal([]byte(v), &keys) == nil {
+			logging.From(ctx).Info("Thumbnails already taken by an earlier attempt", "poster", keys.Poster)
+			r.Poster = keys.Poster
+			r.Thumbnails = keys.Stills
+		} else {
+			tctx, span := tracing.Start(ctx, "thumbnails", attribute.Int("count", p.thumbs.Count))
+			err = p.thumbnails(tctx, processed, analysis, info.Duration, r)
+			tracing.End(span, err)
+
+			// Fail the video so it is retried. The renditions already uploaded are kept by the steps done
+			if err != nil {
+				return fmt.Errorf("Error taking thumbnails of video %s: %w", r.Id, err)
+			}
 
-	if err != nil {
-		return err
+			b, _ := json.Marshal(thumbnailKeys{r.Poster, r.Thumbnails})
+			p.mark(ctx, r.Id, steps.StepThumbnails, string(b))
+		}
 	}
 
-	if p.timecodes != nil {
-		for slot, t := range *p.timecodes {
-			err := p.splitVideoAndUpload(t, r.Duration, processed, r.Id, slot)
+	if p.timelines != nil {
+		// Use the timelines as they are now for the whole video, even if new ones are loaded part way through
+		timeline, ok := p.timelines.Load().Get(r.Timeline)
+		if !ok {
+			return retry.Permanent(fmt.Errorf("Video %s asked for unknown timeline %s", r.Id, r.Timeline))
+		}
+		r.TimelineVersion = timeline.Version
+
+		ctx := logging.With(ctx, logging.KeyTimeline, timeline.Name)
+
+		// Give every slot its own part of the video, so no two slots show the same moment unless they have to
+		windows := segment.Allocate(analysis, info.Duration, slotsOf(timeline))
+
+		for slot, window := range windows {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+
+			// The video is too short for this slot
+			if window == nil {
+				continue
+			}
+
+			ctx := logging.With(ctx, logging.KeyStep, metrics.StepSplit, logging.KeySlot, slot)
+			// Slots cut against another version of the timeline don't count
+			step := steps.Split(timeline.Name, timeline.Version, slot)
+			if v, ok := done.Has(step); ok {
+				split := video.Split{}
+				if json.Unmarshal([]byte(v), &split) == nil {
+					r.Splits = append(r.Splits, split)
+					continue
+				}
+			}
+
+			sctx, span := tracing.Start(ctx, "split",
+				attribute.String("timeline", timeline.Name),
+				attribute.Int("slot", slot),
+				attribute.Float64("start", window.Start),
+				attribute.Float64("end", window.End),
+			)
+			key, err := p.splitVideoAndUpload(sctx, *window, timeline, slot, processed, r.Id)
+			tracing.End(span, err)
+			// Fail the video so it is retried. Slots already uploaded are kept by the steps done
 			if err != nil {
-				log.Printf("Couldn't split video %s into slot %d: %s\n+%+v\n", r.Id, slot, err.Error(), err)
-			} else {
-				log.Printf("Uploaded %s to slot %d", r.Id, slot)
+				return fmt.Errorf("Error splitting video %s into slot %d: %w", r.Id, slot, err)
 			}
 
+			split := video.Split{Timeline: timeline.Name, Slot: slot, Key: key, Start: window.Start, End: window.End}
+			r.Splits = append(r.Splits, split)
+			logging.From(ctx).Info("Uploaded slot", "key", key, "window", window.String())
+
+			b, _ := json.Marshal(split)
+			p.mark(ctx, r.Id, step, string(b))
 		}
 	}
 
+	job.Done()
+
 	return nil
 }
 
-func (p *Processor) uploadSmallVideo(f *os.File, id string) error {
-	// make the video small for other types of processing
-	command := "ffmpeg -y -r 24 -i %s -filter:v scale=320:240 %s"
-	destination := f.Name() + "-small.mp4"
-	args := strings.Split(fmt.Sprintf(command, f.Name(), destination), " ")
+// profilesFor gets the profiles a request should be rendered to. That is every profile, unless the request picked one.
+// The primary profile always comes first, because the slots are cut from it
+func (p *Processor) profilesFor(r *video.VideoRequest) ([]profile.Profile, error) {
+	if r.Profile == "" {
+		return p.profiles, nil
+	}
 
-	cmd := exec.Command(args[0], args[1:]...)
-	_, err := runCommand(cmd)
+	if r.Profile == p.profiles[0].Name {
+		return p.profiles[:1], nil
+	}
 
-	if err != nil {
-		return err
+	pr, ok := profile.Find(p.profiles, r.Profile)
+	if !ok {
+		return nil, retry.Permanent(fmt.Errorf("Video %s asked for unknown profile %s", r.Id, r.Profile))
 	}
 
-	defer os.Remove(destination)
+	return []profile.Profile{p.profiles[0], pr}, nil
+}
 
-	processed, err := os.Open(destination)
+// renderProfile transcodes f into a profile at destination and uploads it under the profile's prefix, returning the key.
+// ffmpeg's progress is written to progress as it goes
+func (p *Processor) renderProfile(ctx context.Context, f *os.File, framerate string, pr profile.Profile, destination, id string, progress io.Writer) (string, error) {
+	start := time.Now()
+	_, err := command.FFmpeg("-nostats", "-progress", "pipe:1", "-r", framerate, "-i", f.Name()).
+		Arg(pr.Args()...).
+		Arg(destination).
+		WithStdout(progress).
+		Run(ctx)
+
+	metrics.FFmpegExit(err)
 	if err != nil {
-		return err
+		return "", err
 	}
+	metrics.Time(metrics.StepTranscode, pr.Name, start)
+
+	rendered, err := os.Open(destination)
+	if err != nil {
+		return "", err
+	}
+
+	defer rendered.Close()
 
-	key := fmt.Sprintf("%s/%s.mp4", p.smallPrefix, id)
+	key := fmt.Sprintf("%s/%s.mp4", pr.Prefix, id)
 
-	err = p.uploadFile(processed, key)
+	err = p.uploadFile(ctx, rendered, key)
 
 	if err != nil {
-		return err
+		return "", err
 	}
 
-	log.Printf("Uploaded %s to s3://%s/%s", id, p.bucket, key)
+	logging.From(ctx).Info("Uploaded rendition", "key", key)
 
-	return nil
+	return key, nil
 }
 
-func (p *Processor) splitVideoAndUpload(timecode timecode.Timecode, duration int, f *os.File, id string, slot int) error {
-	// Check to see if the video can be split into a length specified by timecode.
-	// Try and start the split at 40% through the clip
-	start, err := p.getSplitStart(timecode, float64(duration))
+// analyze runs the split strategy over the processed video. If it fails, the slots fall back to the fixed strategy
+func (p *Processor) analyze(ctx context.Context, path string, duration float64) segment.Analysis {
+	ctx = logging.With(ctx, logging.KeyStep, "analyze")
+	analysis, err := p.strategy.Analyze(ctx, path, duration)
 	if err != nil {
-		if err == VideoTooShort {
-			return nil
-		}
-
-		return err
+		logging.From(ctx).Warn("Couldn't analyze video for splitting, using the fixed strategy", logging.Err(err))
+		analysis, _ = segment.Fixed{}.Analyze(ctx, path, duration)
 	}
 
-	// Make the video to the length specified in the timecode, starting at a specified point
-	command := "ffmpeg -y -r 24 -ss %.9f -i %s -t %.9f %s"
-	destination := fmt.Sprintf("%s-%d-split.mp4", f.Name(), slot)
+	return analysis
+}
 
-	args := strings.Split(fmt.Sprintf(command, start, f.Name(), timecode.Length, destination), " ")
+// splitVideoAndUpload cuts window out of f, uploads it to a slot of the timeline and adds it to the slot's set. It returns the key it uploaded to
+func (p *Processor) splitVideoAndUpload(ctx context.Context, window segment.Window, timeline *timecode.Timeline, slot int, f *os.File, id string) (string, error) {
+	t := timeline.Slots[slot]
 
-	cmd := exec.Command(args[0], args[1:]...)
-	_, err = runCommand(cmd)
+	// Make the video to the length of the window, starting where it does
+	destination := fmt.Sprintf("%s-%s-%d-split.mp4", f.Name(), timeline.Name, slot)
 
-	if err != nil {
-		return err
+	defer os.Remove(destination)
+
+	cmd := command.FFmpeg(
+		"-r", "24",
+		"-ss", fmt.Sprintf("%.9f", window.Start),
+		"-i", f.Name(),
+		"-t", fmt.Sprintf("%.9f", window.Length()),
+	)
+
+	// Crop the slot if its timecode asks for it
+	if filter := t.Filter(); filter != "" {
+		cmd.Arg("-filter:v", filter)
 	}
 
-	defer os.Remove(destination)
+	start := time.Now()
+	_, err := cmd.Arg(destination).Run(ctx)
+
+	metrics.FFmpegExit(err)
+
+	if err != nil {
+		return "", err
+	}
+	metrics.Time(metrics.StepSplit, "", start)
 
 	processed, err := os.Open(destination)
 	if err != nil {
-		return err
+		return "", err
 	}
 
 	defer processed.Close()
 
-	key := fmt.Sprintf("%s/%d/%s.mp4", p.splitPrefix, slot, id)
+	key := fmt.Sprintf("%s/%s/%d/%s.mp4", p.splitPrefix, timeline.Name, slot, id)
 
-	err = p.uploadFile(processed, key)
+	err = p.uploadFile(ctx, processed, key)
 
 	if err != nil {
-		return err
+		return "", err
 	}
 
-	err = p.addKeyToRedisSlot(key, slot)
+	err = p.slots.Add(timeline.Name, slot, key)
 
 	if err != nil {
-		return err
+		return "", err
 	}
 
-	log.Printf("Uploaded %s to s3://%s/%s", id, p.bucket, key)
+	if n, err := p.slots.Count(timeline.Name, slot); err == nil {
+		metrics.SlotClips.WithLabelValues(timeline.Name, strconv.Itoa(slot)).Set(float64(n))
+	}
 
-	return nil
+	return key, nil
 }
 
-// getSplitStart gets the start of
-func (p *Processor) getSplitStart(timecode timecode.Timecode, duration float64) (float64, error) {
-	// check that a video of duration can be split into a length specified by timecode
-	if timecode.Length > duration {
-		return -1, VideoTooShort
-	}
-
-	// Try to split starting at 40% through the video
-	if (duration*0.4)+timecode.Length <= duration {
-		return (duration * 0.4), nil
+// slotsOf gets what the allocator needs to know about each slot in a timeline
+func slotsOf(timeline *timecode.Timeline) []segment.Slot {
+	wanted := make([]segment.Slot, timeline.Len())
+	for i, t := range timeline.Slots {
+		wanted[i] = segment.Slot{Length: t.Length, MinLength: t.MinLength}
+		if t.Anchor != nil {
+			wanted[i].Anchor = *t.Anchor
+			wanted[i].Anchored = true
+		}
 	}
 
-	// If that's not possible, start at the beginning
-	return 0, nil
+	return wanted
 }
 
-func (p *Processor) getDurationInSeconds(filename string) int {
-	args := strings.Split(fmt.Sprintf(`ffprobe -i %s -show_entries format=duration -v quiet -of csv=p=0`, filename), " ")
-	cmd := exec.Command(args[0], args[1:]...)
-	output, err := runCommand(cmd)
+// downloadFile copies the object at key in storage to a file at destination
+func (p *Processor) downloadFile(ctx context.Context, key, destination string) error {
+	r, err := p.storage.Get(ctx, key)
 	if err != nil {
-		log.Println(err)
-		return 0
+		return err
 	}
+	defer r.Close()
 
-	f, err := strconv.ParseFloat(strings.TrimSpace(string(output)), 64)
-
+	f, err := os.Create(destination)
 	if err != nil {
-		log.Println(err)
-		return 0
+		return err
 	}
+	defer f.Close()
 
-	return int(f)
-}
-
-func (p *Processor) addKeyToRedisSlot(key string, slot int) error {
-	err := p.redis.SAdd(string(slot), key).Err()
-	return err
-}
-
-// uploadFile uploads a file to a key on S3
-func (p *Processor) uploadFile(r io.Reader, key string) error {
-	manager := s3manager.NewUploader(p.sess)
-	_, err := manager.Upload(&s3manager.UploadInput{
-		Key:    aws.String(key),
-		Bucket: aws.String(p.bucket),
-		Body:   r,
-	})
-
+	_, err = io.Copy(f, r)
 	return err
 }
 
-// runCommand runs a cmd and gets the output (if any) and error (if any)
-func runCommand(cmd *exec.Cmd) ([]byte, error) {
-	output, err := cmd.CombinedOutput()
+// uploadFile uploads a file to a key in storage. A cancelled upload is aborted rather than left half written
+func (p *Processor) uploadFile(ctx context.Context, r io.Reader, key string) error {
+	ctx, span := tracing.Start(ctx, "put", attribute.String("key", key))
 
+	start := time.Now()
+	err := p.storage.Put(ctx, key, metrics.CountingReader{Reader: r, Counter: metrics.BytesUploaded})
+	tracing.End(span, err)
 	if err != nil {
-		log.Printf("%s returned an error: %s", cmd.Args, err)
+		return err
 	}
-	return output, err
+
+	metrics.Time(metrics.StepUpload, "", start)
+	return nil
 }
@@ -0,0 +1,354 @@
+package processor
+
+import (
+	"context"
+	"fmt"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/therealpenguin/takeabow-upload-processor/command"
+	"github.com/therealpenguin/takeabow-upload-processor/profile"
+	"github.com/therealpenguin/takeabow-upload-processor/retry"
+	"github.com/therealpenguin/takeabow-upload-processor/slots"
+	"github.com/therealpenguin/takeabow-upload-processor/steps"
+	"github.com/therealpenguin/takeabow-upload-processor/storage"
+	"github.com/therealpenguin/takeabow-upload-processor/timecode"
+	"github.com/therealpenguin/takeabow-upload-processor/video"
+	"gopkg.in/redis.v5"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// fakeFFmpeg logs its arguments and writes them to its output file, which is its last argument.
+// It fails instead if an input is missing, or if its arguments contain the pattern in the fail file
+const fakeFFmpeg = `#!/bin/sh
+echo "$*" >> %[1]s
+prev=
+for arg; do
+	if [ "$prev" = "-i" ] && [ ! -f "$arg" ]; then
+		echo "$arg: No such file or directory" >&2
+		exit 1
+	fi
+	prev=$arg
+done
+if [ -s %[2]s ] && echo "$*" | grep -q -- "$(cat %[2]s)"; then
+	echo "Conversion failed!" >&2
+	exit 1
+fi
+for last; do :; done
+echo "$*" > "$last"
+`
+
+// fakeFFprobe describes every file as a minute of 1080p video
+const fakeFFprobe = `#!/bin/sh
+cat <<'JSON'
+{
+	"streams": [{"index": 0, "codec_name": "h264", "codec_type": "video", "width": 1920, "height": 1080, "avg_frame_rate": "24/1"}],
+	"format": {"format_name": "mov,mp4,m4a,3gp,3g2,mj2", "duration": "60.000000", "size": "1000"}
+}
+JSON
+`
+
+// harness runs the processor against memory storage, a Redis of its own and fake ffmpeg and ffprobe binaries
+type harness struct {
+	t       *testing.T
+	dir     string
+	log     string
+	fail    string
+	store   *storage.Memory
+	redis   *miniredis.Miniredis
+	slots   *slots.Registry
+	steps   *steps.Tracker
+	profile []profile.Profile
+}
+
+func newHarness(t *testing.T) *harness {
+	dir := t.TempDir()
+	h := &harness{
+		t:       t,
+		dir:     filepath.Join(dir, "work"),
+		log:     filepath.Join(dir, "ffmpeg.log"),
+		fail:    filepath.Join(dir, "fail"),
+		store:   storage.NewMemory(),
+		redis:   miniredis.RunT(t),
+		profile: profile.Default("processed", "small"),
+	}
+	assert.NoError(t, os.Mkdir(h.dir, 0755))
+
+	ffmpeg := filepath.Join(dir, "ffmpeg")
+	ffprobe := filepath.Join(dir, "ffprobe")
+	assert.NoError(t, os.WriteFile(ffmpeg, []byte(fmt.Sprintf(fakeFFmpeg, h.log, h.fail)), 0755))
+	assert.NoError(t, os.WriteFile(ffprobe, []byte(fakeFFprobe), 0755))
+	command.Configure(command.Config{FFmpeg: ffmpeg, FFprobe: ffprobe})
+	t.Cleanup(func() { command.Configure(command.DefaultConfig) })
+
+	client := redis.NewClient(&redis.Options{Addr: h.redis.Addr()})
+	t.Cleanup(func() { client.Close() })
+	h.slots = slots.New(client, "bow")
+	h.steps = steps.New(client, "bow")
+
+	return h
+}
+
+// processor makes a processor with c, filling in the harness's storage, Redis, directory and profiles
+func (h *harness) processor(c Config) *Processor {
+	c.Storage = h.store
+	c.Dir = h.dir
+	c.Slots = h.slots
+	c.Steps = h.steps
+	c.SplitPrefix = "split"
+	if c.Profiles == nil {
+		c.Profiles = h.profile
+	}
+
+	return New(c)
+}
+
+// upload puts an original video in storage, and gets the video for a request to process it
+func (h *harness) upload(body string) video.Video {
+	r, err := video.NewVideoRequest([]byte(body))
+	assert.NoError(h.t, err)
+	assert.NoError(h.t, h.store.Put(context.Background(), "upload/"+r.Id+".mp4", strings.NewReader("original")))
+
+	v, err := video.FromRequest(r, h.store)
+	assert.NoError(h.t, err)
+	return v
+}
+
+// failOn makes ffmpeg fail whenever its arguments contain pattern. An empty pattern lets it succeed again
+func (h *harness) failOn(pattern string) {
+	assert.NoError(h.t, os.WriteFile(h.fail, []byte(pattern), 0644))
+}
+
+// runs gets the arguments of every ffmpeg run so far, and forgets them
+func (h *harness) runs() []string {
+	b, err := os.ReadFile(h.log)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	assert.NoError(h.t, err)
+	os.Remove(h.log)
+
+	return strings.Split(strings.TrimSpace(string(b)), "\n")
+}
+
+// get reads an object from storage
+func (h *harness) get(key string) string {
+	r, err := h.store.Get(context.Background(), key)
+	if !assert.NoError(h.t, err, key) {
+		return ""
+	}
+	defer r.Close()
+
+	b, err := io.ReadAll(r)
+	assert.NoError(h.t, err)
+	return string(b)
+}
+
+// timelines makes a store of one timeline, the default, with a slot of each length
+func timelines(t *testing.T, name, version string, lengths ...string) *timecode.Store {
+	timeline, err := timecode.Load(strings.NewReader(strings.Join(lengths, "\n")), "csv")
+	assert.NoError(t, err)
+	timeline.Name = name
+	timeline.Version = version
+
+	return timecode.NewStore(timecode.Single(timeline))
+}
+
+// count gets how many runs contain s
+func count(runs []string, s string) int {
+	n := 0
+	for _, run := range runs {
+		if strings.Contains(run, s) {
+			n++
+		}
+	}
+
+	return n
+}
+
+func TestProcessUploadsEverythingToStorage(t *testing.T) {
+	h := newHarness(t)
+	p := h.processor(Config{Timelines: timelines(t, "default", "1", "5", "5")})
+
+	v := h.upload(`{"id": "abc", "url": "https://takeabow.s3.amazonaws.com/upload/abc.mp4"}`)
+	assert.NoError(t, p.Process(context.Background(), v))
+
+	r := v.GetRequest()
+	assert.Equal(t, 60, r.Duration)
+	assert.Equal(t, map[string]string{"processed": "processed/abc.mp4", "small": "small/abc.mp4"}, r.Renditions)
+	assert.Equal(t, h.profile[0].Version(), r.ProfileVersion)
+
+	// Each rendition is made from the original, and each slot from the primary rendition
+	assert.Contains(t, h.get("processed/abc.mp4"), "/abc ")
+	assert.Contains(t, h.get("small/abc.mp4"), "/abc ")
+	assert.Len(t, r.Splits, 2)
+	for slot, split := range r.Splits {
+		assert.Equal(t, fmt.Sprintf("split/default/%d/abc.mp4", slot), split.Key)
+		assert.Contains(t, h.get(split.Key), "abc-processed.mp4")
+
+		clips, err := h.slots.List("default", slot)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{split.Key}, clips)
+	}
+
+	// Nothing is left behind in the working directory
+	entries, err := os.ReadDir(h.dir)
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestProcessCarriesOnFromAnEarlierAttempt(t *testing.T) {
+	h := newHarness(t)
+	p := h.processor(Config{Timelines: timelines(t, "default", "1", "5", "5", "5")})
+	body := `{"id": "abc", "url": "https://takeabow.s3.amazonaws.com/upload/abc.mp4"}`
+
+	h.failOn("default-1-split")
+	assert.Error(t, p.Process(context.Background(), h.upload(body)))
+	runs := h.runs()
+	assert.Equal(t, 2, count(runs, "-progress"))
+	assert.Equal(t, 2, count(runs, "-split.mp4"))
+
+	// The renditions and the first slot aren't made again, but the primary rendition is downloaded to cut the rest from
+	h.failOn("")
+	v := h.upload(body)
+	assert.NoError(t, p.Process(context.Background(), v))
+	runs = h.runs()
+	assert.Equal(t, 0, count(runs, "-progress"))
+	assert.Equal(t, 2, count(runs, "-split.mp4"))
+	assert.Equal(t, 0, count(runs, "default-0-split"))
+
+	r := v.GetRequest()
+	assert.Equal(t, map[string]string{"processed": "processed/abc.mp4", "small": "small/abc.mp4"}, r.Renditions)
+	assert.Len(t, r.Splits, 3)
+}
+
+func TestProcessSplitsAgainForANewVersionOfTheTimeline(t *testing.T) {
+	h := newHarness(t)
+	body := `{"id": "abc", "url": "https://takeabow.s3.amazonaws.com/upload/abc.mp4"}`
+
+	p := h.processor(Config{Timelines: timelines(t, "default", "1", "5", "5")})
+	assert.NoError(t, p.Process(context.Background(), h.upload(body)))
+	assert.Equal(t, 2, count(h.runs(), "-split.mp4"))
+
+	// Redelivered after the timeline changed, the renditions are kept but every slot is cut again, however often it's retried
+	p = h.processor(Config{Timelines: timelines(t, "default", "2", "5", "5")})
+	for i := 0; i < 2; i++ {
+		v := h.upload(body)
+		assert.NoError(t, p.Process(context.Background(), v))
+		runs := h.runs()
+		assert.Equal(t, 0, count(runs, "-progress"))
+		assert.Equal(t, 2-2*i, count(runs, "-split.mp4"))
+		assert.Equal(t, "2", v.GetRequest().TimelineVersion)
+		assert.Len(t, v.GetRequest().Splits, 2)
+	}
+}
+
+func TestProcessGivesEachSlotItsOwnPartOfTheVideo(t *testing.T) {
+	h := newHarness(t)
+	p := h.processor(Config{Timelines: timelines(t, "default", "1", "5", "10", "5", "20")})
+
+	v := h.upload(`{"id": "abc", "url": "https://takeabow.s3.amazonaws.com/upload/abc.mp4"}`)
+	assert.NoError(t, p.Process(context.Background(), v))
+
+	splits := v.GetRequest().Splits
+	assert.Len(t, splits, 4)
+	sort.Slice(splits, func(i, j int) bool { return splits[i].Start < splits[j].Start })
+	for i, split := range splits {
+		assert.GreaterOrEqual(t, split.Start, 0.0)
+		assert.LessOrEqual(t, split.End, 60.0)
+		if i > 0 {
+			assert.LessOrEqual(t, splits[i-1].End, split.Start, "slots %d and %d overlap", splits[i-1].Slot, split.Slot)
+		}
+
+		// Each slot is cut from the range it recorded
+		assert.Contains(t, h.get(split.Key), fmt.Sprintf("-ss %.9f", split.Start))
+	}
+}
+
+func TestProcessFailsWhenASlotCantBeCut(t *testing.T) {
+	h := newHarness(t)
+	p := h.processor(Config{Timelines: timelines(t, "default", "1", "5", "5", "5")})
+
+	h.failOn("default-1-split")
+	err := p.Process(context.Background(), h.upload(`{"id": "abc", "url": "https://takeabow.s3.amazonaws.com/upload/abc.mp4"}`))
+	assert.ErrorContains(t, err, "slot 1")
+	assert.False(t, retry.IsPermanent(err))
+
+	// Slots after the one that failed aren't cut until the video is retried
+	assert.Equal(t, 0, count(h.runs(), "default-2-split"))
+}
+
+func TestProcessSplitsAgainstTheRequestedTimeline(t *testing.T) {
+	h := newHarness(t)
+	dir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "default.csv"), []byte("5\n5\n5\n"), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "encore.csv"), []byte("10\n10\n"), 0644))
+	loaded, err := timecode.LoadDir(dir, "default")
+	assert.NoError(t, err)
+	p := h.processor(Config{Timelines: timecode.NewStore(loaded)})
+
+	v := h.upload(`{"id": "abc", "url": "https://takeabow.s3.amazonaws.com/upload/abc.mp4", "timeline": "encore"}`)
+	assert.NoError(t, p.Process(context.Background(), v))
+
+	splits := v.GetRequest().Splits
+	assert.Len(t, splits, 2)
+	for _, split := range splits {
+		assert.Equal(t, "encore", split.Timeline)
+		assert.Equal(t, fmt.Sprintf("split/encore/%d/abc.mp4", split.Slot), split.Key)
+		assert.Equal(t, 10.0, split.End-split.Start)
+
+		clips, err := h.slots.List("encore", split.Slot)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{split.Key}, clips)
+	}
+
+	// Nothing went into the default timeline's slots
+	clips, err := h.slots.List("default", 0)
+	assert.NoError(t, err)
+	assert.Empty(t, clips)
+
+	// A timeline that isn't loaded won't be by retrying
+	v = h.upload(`{"id": "def", "url": "https://takeabow.s3.amazonaws.com/upload/def.mp4", "timeline": "finale"}`)
+	err = p.Process(context.Background(), v)
+	assert.True(t, retry.IsPermanent(err))
+	assert.Empty(t, v.GetRequest().Splits)
+}
+
+func TestProcessTakesThumbnails(t *testing.T) {
+	h := newHarness(t)
+	thumbs := Thumbnails{Prefix: "thumbs", Count: 3, Format: FormatJPEG, Width: 320}
+	p := h.processor(Config{Thumbnails: thumbs})
+	body := `{"id": "abc", "url": "https://takeabow.s3.amazonaws.com/upload/abc.mp4"}`
+
+	// A still that can't be taken fails the video, so it's retried
+	h.failOn("-ss 30.000000000")
+	err := p.Process(context.Background(), h.upload(body))
+	assert.ErrorContains(t, err, "thumbnails")
+	assert.False(t, retry.IsPermanent(err))
+	assert.Equal(t, 2, count(h.runs(), "-progress"))
+
+	// Retried, the renditions are kept and the thumbnails are taken from the primary one
+	h.failOn("")
+	v := h.upload(body)
+	assert.NoError(t, p.Process(context.Background(), v))
+	runs := h.runs()
+	assert.Equal(t, 0, count(runs, "-progress"))
+	assert.Equal(t, 4, count(runs, "abc-processed.mp4 -frames:v 1"))
+
+	r := v.GetRequest()
+	assert.Equal(t, "thumbs/abc/poster.jpg", r.Poster)
+	assert.Equal(t, []string{"thumbs/abc/00.jpg", "thumbs/abc/01.jpg", "thumbs/abc/02.jpg"}, r.Thumbnails)
+	for _, key := range append([]string{r.Poster}, r.Thumbnails...) {
+		assert.Contains(t, h.get(key), "scale=320:-2")
+	}
+
+	// Once taken, they aren't taken again
+	v = h.upload(body)
+	assert.NoError(t, p.Process(context.Background(), v))
+	assert.Empty(t, h.runs())
+	assert.Equal(t, r.Thumbnails, v.GetRequest().Thumbnails)
+}
@@ -0,0 +1,114 @@
+package processor
+
+import (
+	"context"
+	"fmt"
+	"github.com/therealpenguin/takeabow-upload-processor/command"
+	"github.com/therealpenguin/takeabow-upload-processor/logging"
+	"github.com/therealpenguin/takeabow-upload-processor/metrics"
+	"github.com/therealpenguin/takeabow-upload-processor/segment"
+	"github.com/therealpenguin/takeabow-upload-processor/video"
+	"os"
+	"time"
+)
+
+const FormatJPEG = "jpg"
+const FormatWebP = "webp"
+
+// Thumbnails is which stills to take of each video. No Prefix means none are taken
+type Thumbnails struct {
+	Prefix string `json:"prefix"`
+	Count  int    `json:"count"`
+	Format string `json:"format"`
+	Width  int    `json:"width"`
+}
+
+// Enabled reports whether stills should be taken
+func (t Thumbnails) Enabled() bool {
+	return t.Prefix != ""
+}
+
+// Validate checks the stills can be made
+func (t Thumbnails) Validate() error {
+	if t.Format != FormatJPEG && t.Format != FormatWebP {
+		return fmt.Errorf("Unknown thumbnail format %q, it should be %s or %s", t.Format, FormatJPEG, FormatWebP)
+	}
+
+	if t.Count < 0 || t.Width < 1 {
+		return fmt.Errorf("Thumbnails need a count of at least 0 and a width of at least 1")
+	}
+
+	return nil
+}
+
+// thumbnailKeys is what the thumbnails step leaves behind, so a redelivered video doesn't take them again
+type thumbnailKeys struct {
+	Poster string   `json:"poster"`
+	Stills []string `json:"stills"`
+}
+
+// thumbnails takes a poster frame and a grid of stills from f, uploads them and records their keys on r
+func (p *Processor) thumbnails(ctx context.Context, f *os.File, analysis segment.Analysis, duration float64, r *video.VideoRequest) error {
+	start := time.Now()
+
+	poster := fmt.Sprintf("%s/%s/poster.%s", p.thumbs.Prefix, r.Id, p.thumbs.Format)
+	err := p.still(ctx, f, segment.Poster(analysis, duration), poster)
+	if err != nil {
+		return err
+	}
+
+	stills := make([]string, 0, p.thumbs.Count)
+	for i, at := range segment.Spread(duration, p.thumbs.Count) {
+		key := fmt.Sprintf("%s/%s/%02d.%s", p.thumbs.Prefix, r.Id, i, p.thumbs.Format)
+		err = p.still(ctx, f, at, key)
+		if err != nil {
+			return err
+		}
+		stills = append(stills, key)
+	}
+
+	metrics.Time(metrics.StepThumbnails, "", start)
+	logging.From(ctx).Info("Uploaded thumbnails", "poster", poster, "stills", len(stills))
+
+	r.Poster = poster
+	r.Thumbnails = stills
+
+	return nil
+}
+
+// still takes the frame at seconds into f, scaled to the thumbnail width, and uploads it to key
+func (p *Processor) still(ctx context.Context, f *os.File, at float64, key string) error {
+	destination := fmt.Sprintf("%s-still.%s", f.Name(), p.thumbs.Format)
+
+	defer os.Remove(destination)
+
+	cmd := command.FFmpeg(
+		"-ss", fmt.Sprintf("%.9f", at),
+		"-i", f.Name(),
+		"-frames:v", "1",
+		"-vf", fmt.Sprintf("scale=%d:-2", p.thumbs.Width),
+	)
+
+	if p.thumbs.Format == FormatWebP {
+		cmd.Arg("-c:v", "libwebp", "-quality", "80")
+	} else {
+		cmd.Arg("-q:v", "2")
+	}
+
+	_, err := cmd.Arg(destination).Run(ctx)
+
+	metrics.FFmpegExit(err)
+
+	if err != nil {
+		return err
+	}
+
+	still, err := os.Open(destination)
+	if err != nil {
+		return err
+	}
+
+	defer still.Close()
+
+	return p.uploadFile(ctx, still, key)
+}
@@ -0,0 +1,167 @@
+package profile
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Profile describes one rendition that every upload is transcoded into
+type Profile struct {
+	Name        string `json:"name"`
+	Width       int    `json:"width"`
+	Height      int    `json:"height"`
+	Aspect      string `json:"aspect,omitempty"`
+	FPS         int    `json:"fps,omitempty"`
+	Codec       string `json:"codec,omitempty"`
+	CRF         int    `json:"crf,omitempty"`
+	Bitrate     string `json:"bitrate,omitempty"`
+	PixelFormat string `json:"pixel_format,omitempty"`
+	Audio       bool   `json:"audio"`
+	Prefix      string `json:"prefix"`
+}
+
+const NameProcessed = "processed"
+const NameSmall = "small"
+
+// Default gets the profiles we have always rendered: a 1080p, 24fps video that slots are cut from, and a small video for other processing
+func Default(processedPrefix, smallPrefix string) []Profile {
+	return []Profile{
+		{
+			Name:        NameProcessed,
+			Width:       1920,
+			Height:      1080,
+			Aspect:      "16/9",
+			FPS:         24,
+			Codec:       "libx264",
+			PixelFormat: "yuv420p",
+			Prefix:      processedPrefix,
+		},
+		{
+			Name:   NameSmall,
+			Width:  320,
+			Height: 240,
+			FPS:    24,
+			Prefix: smallPrefix,
+		},
+	}
+}
+
+// Load reads a JSON array of profiles. The first profile is the primary one, which slots are cut from
+func Load(r io.Reader) ([]Profile, error) {
+	profiles := make([]Profile, 0)
+	err := json.NewDecoder(r).Decode(&profiles)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(profiles) == 0 {
+		return nil, errors.New("No profiles are configured")
+	}
+
+	names := make(map[string]bool)
+	for i, p := range profiles {
+		err := p.Validate()
+		if err != nil {
+			return nil, fmt.Errorf("Profile %d is invalid: %s", i, err)
+		}
+
+		if names[p.Name] {
+			return nil, fmt.Errorf("Profile %s is configured more than once", p.Name)
+		}
+		names[p.Name] = true
+	}
+
+	return profiles, nil
+}
+
+// LoadFile reads profiles from a JSON file
+func LoadFile(path string) ([]Profile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return Load(f)
+}
+
+// Validate checks a profile has everything it needs to be rendered
+func (p Profile) Validate() error {
+	if p.Name == "" {
+		return errors.New("name is empty")
+	}
+
+	if p.Width <= 0 || p.Height <= 0 {
+		return errors.New("width and height must be positive")
+	}
+
+	if p.Prefix == "" {
+		return errors.New("prefix is empty")
+	}
+
+	if p.CRF != 0 && p.Bitrate != "" {
+		return errors.New("only one of crf and bitrate can be set")
+	}
+
+	return nil
+}
+
+// Args gets the ffmpeg output options that render the profile
+func (p Profile) Args() []string {
+	filter := fmt.Sprintf("scale=%d:%d", p.Width, p.Height)
+	if p.Aspect != "" {
+		filter += ",setdar=" + p.Aspect
+	}
+
+	args := []string{"-filter:v", filter}
+
+	if p.FPS > 0 {
+		args = append(args, "-r", strconv.Itoa(p.FPS))
+	}
+
+	if p.Codec != "" {
+		args = append(args, "-c:v", p.Codec)
+	}
+
+	if p.CRF > 0 {
+		args = append(args, "-crf", strconv.Itoa(p.CRF))
+	}
+
+	if p.Bitrate != "" {
+		args = append(args, "-b:v", p.Bitrate)
+	}
+
+	if p.PixelFormat != "" {
+		args = append(args, "-pix_fmt", p.PixelFormat)
+	}
+
+	if !p.Audio {
+		args = append(args, "-an")
+	}
+
+	return args
+}
+
+// Version identifies how the profile renders, changing whenever its ffmpeg options do
+func (p Profile) Version() string {
+	sum := sha256.Sum256([]byte(strings.Join(p.Args(), " ")))
+	return hex.EncodeToString(sum[:6])
+}
+
+// Find gets the profile called name
+func Find(profiles []Profile, name string) (Profile, bool) {
+	for _, p := range profiles {
+		if p.Name == name {
+			return p, true
+		}
+	}
+
+	return Profile{}, false
+}
@@ -0,0 +1,62 @@
+package profile
+
+import (
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func TestDefaultArgs(t *testing.T) {
+	profiles := Default("processed", "small")
+
+	assert.Equal(t,
+		[]string{"-filter:v", "scale=1920:1080,setdar=16/9", "-r", "24", "-c:v", "libx264", "-pix_fmt", "yuv420p", "-an"},
+		profiles[0].Args())
+	assert.Equal(t,
+		[]string{"-filter:v", "scale=320:240", "-r", "24", "-an"},
+		profiles[1].Args())
+}
+
+func TestLoad(t *testing.T) {
+	profiles, err := Load(strings.NewReader(`[
+		{"name": "hd", "width": 1280, "height": 720, "fps": 30, "codec": "libx264", "crf": 23, "audio": true, "prefix": "hd"},
+		{"name": "tiny", "width": 160, "height": 120, "bitrate": "200k", "prefix": "tiny"}
+	]`))
+	assert.Nil(t, err)
+	assert.Len(t, profiles, 2)
+	assert.Equal(t,
+		[]string{"-filter:v", "scale=1280:720", "-r", "30", "-c:v", "libx264", "-crf", "23"},
+		profiles[0].Args())
+
+	p, ok := Find(profiles, "tiny")
+	assert.True(t, ok)
+	assert.Equal(t, "200k", p.Bitrate)
+}
+
+func TestLoadInvalid(t *testing.T) {
+	testcases := []string{
+		`[]`,
+		`[{"name": "hd", "width": 1280, "height": 720}]`,
+		`[{"name": "hd", "width": 1280, "height": 720, "prefix": "hd", "crf": 23, "bitrate": "1M"}]`,
+		`[{"name": "hd", "width": 1280, "height": 720, "prefix": "hd"}, {"name": "hd", "width": 640, "height": 360, "prefix": "sd"}]`,
+	}
+
+	for _, tc := range testcases {
+		_, err := Load(strings.NewReader(tc))
+		assert.NotNil(t, err, tc)
+	}
+}
+
+func TestVersion(t *testing.T) {
+	processed := Default("processed", "small")[0]
+	assert.Equal(t, processed.Version(), processed.Version())
+
+	// Where the rendition is uploaded to doesn't change how it's rendered
+	moved := processed
+	moved.Prefix = "renditions"
+	assert.Equal(t, processed.Version(), moved.Version())
+
+	sharper := processed
+	sharper.CRF = 18
+	assert.NotEqual(t, processed.Version(), sharper.Version())
+}
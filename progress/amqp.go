@@ -0,0 +1,38 @@
+package progress
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/streadway/amqp"
+)
+
+// ExchangeProgress is the topic exchange reports are published to, with the video's id as the routing key,
+// so a consumer can bind to one video or to "#" for all of them
+const ExchangeProgress = "upload.progress"
+
+// AMQP publishes reports as JSON, routed by video id
+type AMQP struct {
+	channel func() *amqp.Channel
+}
+
+// NewAMQP publishes on whichever channel the function returns, so it keeps working after a reconnect
+func NewAMQP(channel func() *amqp.Channel) *AMQP {
+	return &AMQP{channel}
+}
+
+func (a *AMQP) Report(rep Report) error {
+	ch := a.channel()
+	if ch == nil {
+		return errors.New("No channel to publish progress on")
+	}
+
+	body, err := json.Marshal(rep)
+	if err != nil {
+		return err
+	}
+
+	return ch.Publish(ExchangeProgress, rep.VideoID, false, false, amqp.Publishing{
+		ContentType: "application/json",
+		Body:        body,
+	})
+}
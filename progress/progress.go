@@ -0,0 +1,191 @@
+package progress
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/therealpenguin/takeabow-upload-processor/logging"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Interval is how often a job reports while ffmpeg is running
+const Interval = time.Second
+
+// Report is how far through processing a video is
+type Report struct {
+	VideoID   string        `json:"id"`
+	Step      string        `json:"step"`
+	Percent   float64       `json:"percent"`
+	ETA       time.Duration `json:"-"`
+	Done      bool          `json:"done"`
+	UpdatedAt time.Time     `json:"updated_at"`
+}
+
+// MarshalJSON gives the ETA in whole seconds as eta_seconds, the same as in Redis
+func (r Report) MarshalJSON() ([]byte, error) {
+	type report Report
+	return json.Marshal(struct {
+		report
+		ETASeconds int `json:"eta_seconds"`
+	}{report(r), int(r.ETA / time.Second)})
+}
+
+// Reporter publishes reports somewhere the frontend can see them
+type Reporter interface {
+	Report(r Report) error
+}
+
+// Multi reports to every one of its reporters
+type Multi []Reporter
+
+func (m Multi) Report(r Report) error {
+	var first error
+	for _, reporter := range m {
+		err := reporter.Report(r)
+		if err != nil && first == nil {
+			first = err
+		}
+	}
+
+	return first
+}
+
+// Parser reads ffmpeg's -progress output and calls fn with the fraction of the input that has been written
+type Parser struct {
+	duration float64
+	fn       func(fraction float64, done bool)
+	buf      []byte
+	outTime  float64
+}
+
+// NewParser creates a Parser for an input that is duration seconds long
+func NewParser(duration float64, fn func(fraction float64, done bool)) *Parser {
+	return &Parser{
+		duration: duration,
+		fn:       fn,
+	}
+}
+
+// Write takes ffmpeg's output, which comes in blocks of key=value lines that end with a progress line
+func (p *Parser) Write(b []byte) (int, error) {
+	p.buf = append(p.buf, b...)
+
+	for {
+		i := bytes.IndexByte(p.buf, '\n')
+		if i < 0 {
+			break
+		}
+
+		p.line(strings.TrimSpace(string(p.buf[:i])))
+		p.buf = p.buf[i+1:]
+	}
+
+	return len(b), nil
+}
+
+func (p *Parser) line(line string) {
+	parts := strings.SplitN(line, "=", 2)
+	if len(parts) != 2 {
+		return
+	}
+
+	switch parts[0] {
+	case "out_time_us", "out_time_ms":
+		// Despite its name, out_time_ms is in microseconds too
+		us, err := strconv.ParseInt(parts[1], 10, 64)
+		if err == nil && us >= 0 {
+			p.outTime = float64(us) / 1e6
+		}
+	case "progress":
+		done := parts[1] == "end"
+		p.fn(p.fraction(done), done)
+	}
+}
+
+func (p *Parser) fraction(done bool) float64 {
+	if done {
+		return 1
+	}
+
+	if p.duration <= 0 {
+		return 0
+	}
+
+	f := p.outTime / p.duration
+	if f > 1 {
+		return 1
+	}
+
+	return f
+}
+
+// Job tracks a video through a number of equally weighted steps, reporting its overall percentage and ETA
+type Job struct {
+	id       string
+	steps    int
+	reporter Reporter
+	start    time.Time
+	mu       sync.Mutex
+	last     time.Time
+	now      func() time.Time
+}
+
+// NewJob starts tracking a video. A nil reporter reports nowhere
+func NewJob(id string, steps int, reporter Reporter) *Job {
+	return &Job{
+		id:       id,
+		steps:    steps,
+		reporter: reporter,
+		start:    time.Now(),
+		now:      time.Now,
+	}
+}
+
+// Step gets a writer for ffmpeg's progress output while the job is on step i, which works through duration seconds of input
+func (j *Job) Step(i int, name string, duration float64) *Parser {
+	return NewParser(duration, func(fraction float64, done bool) {
+		j.update(name, (float64(i)+fraction)/float64(j.steps), false)
+	})
+}
+
+// Done reports that the job has finished every step
+func (j *Job) Done() {
+	j.update("done", 1, true)
+}
+
+func (j *Job) update(step string, fraction float64, done bool) {
+	if j.reporter == nil {
+		return
+	}
+
+	j.mu.Lock()
+	now := j.now()
+	if !done && now.Sub(j.last) < Interval {
+		j.mu.Unlock()
+		return
+	}
+	j.last = now
+	j.mu.Unlock()
+
+	r := Report{
+		VideoID:   j.id,
+		Step:      step,
+		Percent:   fraction * 100,
+		Done:      done,
+		UpdatedAt: now,
+	}
+
+	// Assume the rest takes as long per percent as what we've done so far
+	if fraction > 0 && fraction < 1 {
+		elapsed := now.Sub(j.start)
+		r.ETA = time.Duration(float64(elapsed) * (1 - fraction) / fraction).Round(time.Second)
+	}
+
+	err := j.reporter.Report(r)
+	if err != nil {
+		slog.Warn("Couldn't report progress", logging.KeyVideoID, j.id, logging.KeyStep, r.Step, logging.Err(err))
+	}
+}
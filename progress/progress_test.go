@@ -0,0 +1,70 @@
+package progress
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+type recorder []Report
+
+func (r *recorder) Report(rep Report) error {
+	*r = append(*r, rep)
+	return nil
+}
+
+func TestRedisKey(t *testing.T) {
+	assert.Equal(t, "bow:progress:123", NewRedis(nil, "bow").Key("123"))
+}
+
+func TestParser(t *testing.T) {
+	fractions := make([]float64, 0)
+	p := NewParser(10, func(fraction float64, done bool) {
+		fractions = append(fractions, fraction)
+	})
+
+	// Blocks can be split anywhere
+	p.Write([]byte("frame=10\nout_time_us=2500000\nout_time=00:00:02.5"))
+	p.Write([]byte("00000\nprogress=continue\nout_time_ms=5000000\nprogress=con"))
+	p.Write([]byte("tinue\nout_time_us=N/A\nprogress=continue\nprogress=end\n"))
+
+	assert.Equal(t, []float64{0.25, 0.5, 0.5, 1}, fractions)
+}
+
+func TestJob(t *testing.T) {
+	r := &recorder{}
+	j := NewJob("foo", 2, r)
+
+	start := j.start
+	now := start
+	j.now = func() time.Time { return now }
+
+	now = start.Add(10 * time.Second)
+	j.Step(0, "processed", 100).Write([]byte("out_time_us=50000000\nprogress=continue\n"))
+
+	// Too soon after the last report
+	now = now.Add(100 * time.Millisecond)
+	j.Step(0, "processed", 100).Write([]byte("out_time_us=60000000\nprogress=continue\n"))
+
+	now = start.Add(20 * time.Second)
+	j.Step(1, "small", 100).Write([]byte("out_time_us=50000000\nprogress=continue\n"))
+
+	j.Done()
+
+	assert.Len(t, *r, 3)
+	assert.Equal(t, "processed", (*r)[0].Step)
+	assert.Equal(t, 25.0, (*r)[0].Percent)
+	assert.Equal(t, 30*time.Second, (*r)[0].ETA)
+	assert.Equal(t, "small", (*r)[1].Step)
+	assert.Equal(t, 75.0, (*r)[1].Percent)
+	assert.Equal(t, 7*time.Second, (*r)[1].ETA)
+	assert.True(t, (*r)[2].Done)
+	assert.Equal(t, 100.0, (*r)[2].Percent)
+}
+
+func TestReportJSON(t *testing.T) {
+	b, err := json.Marshal(Report{VideoID: "foo", Step: "small", Percent: 75, ETA: 7 * time.Second})
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"id": "foo", "step": "small", "percent": 75, "eta_seconds": 7, "done": false, "updated_at": "0001-01-01T00:00:00Z"}`, string(b))
+}
@@ -0,0 +1,44 @@
+package progress
+
+import (
+	"fmt"
+	"gopkg.in/redis.v5"
+	"strconv"
+	"time"
+)
+
+// RedisTTL is how long a video's progress is kept after its last report
+const RedisTTL = 24 * time.Hour
+
+// Redis keeps the latest report for each video in a hash
+type Redis struct {
+	client    *redis.Client
+	namespace string
+}
+
+// NewRedis keeps reports under namespace, like the slots and steps
+func NewRedis(client *redis.Client, namespace string) *Redis {
+	return &Redis{client, namespace}
+}
+
+// Key gets the name of a video's hash, like "bow:progress:123"
+func (r *Redis) Key(id string) string {
+	return fmt.Sprintf("%s:progress:%s", r.namespace, id)
+}
+
+func (r *Redis) Report(rep Report) error {
+	key := r.Key(rep.VideoID)
+	_, err := r.client.Pipelined(func(pipe *redis.Pipeline) error {
+		pipe.HMSet(key, map[string]string{
+			"step":        rep.Step,
+			"percent":     strconv.FormatFloat(rep.Percent, 'f', 1, 64),
+			"eta_seconds": strconv.Itoa(int(rep.ETA / time.Second)),
+			"done":        strconv.FormatBool(rep.Done),
+			"updated_at":  rep.UpdatedAt.UTC().Format(time.RFC3339),
+		})
+		pipe.Expire(key, RedisTTL)
+		return nil
+	})
+
+	return err
+}
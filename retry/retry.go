@@ -0,0 +1,55 @@
+package retry
+
+import (
+	"errors"
+	"time"
+)
+
+// permanentError wraps an error that retrying won't fix
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+// Permanent marks err as a failure that should not be retried, such as a missing or unreadable video
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	return &permanentError{err}
+}
+
+// IsPermanent reports whether err, or anything it wraps, was marked as Permanent
+func IsPermanent(err error) bool {
+	var p *permanentError
+	return errors.As(err, &p)
+}
+
+// Backoff gets the delay before the given attempt, doubling base for every attempt and capping it at max
+func Backoff(attempt int, base, max time.Duration) time.Duration {
+	if attempt < 1 {
+		return base
+	}
+
+	d := base
+	for i := 1; i < attempt; i++ {
+		d *= 2
+		if d >= max {
+			return max
+		}
+	}
+
+	if d > max {
+		return max
+	}
+
+	return d
+}
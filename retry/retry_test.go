@@ -0,0 +1,39 @@
+package retry
+
+import (
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestIsPermanent(t *testing.T) {
+	err := errors.New("no video")
+
+	assert.False(t, IsPermanent(err))
+	assert.True(t, IsPermanent(Permanent(err)))
+	assert.True(t, IsPermanent(fmt.Errorf("processing: %w", Permanent(err))))
+	assert.Nil(t, Permanent(nil))
+	assert.Equal(t, "no video", Permanent(err).Error())
+}
+
+func TestBackoff(t *testing.T) {
+	type TestCase struct {
+		Attempt  int
+		Expected time.Duration
+	}
+
+	testcases := []TestCase{
+		{Attempt: 0, Expected: 30 * time.Second},
+		{Attempt: 1, Expected: 30 * time.Second},
+		{Attempt: 2, Expected: time.Minute},
+		{Attempt: 3, Expected: 2 * time.Minute},
+		{Attempt: 10, Expected: time.Hour},
+	}
+
+	for _, tc := range testcases {
+		actual := Backoff(tc.Attempt, 30*time.Second, time.Hour)
+		assert.Equal(t, tc.Expected, actual)
+	}
+}
@@ -0,0 +1,124 @@
+package segment
+
+import (
+	"math"
+	"sort"
+)
+
+// Slot is a window Allocate should find. A slot with a MinLength can be cut shorter than Length, down to MinLength,
+// when the video is too short for it. An anchored slot would rather start Anchor of the way through the video
+type Slot struct {
+	Length    float64
+	MinLength float64
+	Anchor    float64
+	Anchored  bool
+}
+
+// length gets how long the slot's window is in a video of duration seconds, and whether it fits at all
+func (s Slot) length(duration float64) (float64, bool) {
+	if s.Length <= duration {
+		return s.Length, true
+	}
+
+	if s.MinLength > 0 && s.MinLength <= duration {
+		return duration, true
+	}
+
+	return 0, false
+}
+
+// Allocate gives each slot its own window of a video of duration seconds. Where the video is long enough
+// the windows don't overlap; where it isn't, they overlap as little as possible. Within that, each window is
+// the best the analysis can find. Slots that don't fit in the video get a nil window
+func Allocate(a Analysis, duration float64, slots []Slot) []*Window {
+	windows := make([]*Window, len(slots))
+
+	// Place the longest first, they are the hardest to fit
+	lengths := make([]float64, len(slots))
+	order := make([]int, 0, len(slots))
+	total := 0.0
+	for i, s := range slots {
+		l, ok := s.length(duration)
+		if ok {
+			lengths[i] = l
+			order = append(order, i)
+			total += l
+		}
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return lengths[order[i]] > lengths[order[j]]
+	})
+
+	placed := make([]Window, 0, len(order))
+	overlapping := false
+	for _, i := range order {
+		w := bestAvoiding(a, duration, lengths[i], slots[i], placed)
+		if overlapOf(w, placed) > 0 {
+			overlapping = true
+		}
+
+		placed = append(placed, w)
+		windows[i] = &w
+	}
+
+	// Picking windows one at a time can leave gaps too small for the rest. If they would all fit end to end,
+	// lay them out that way instead, keeping their order and sharing the spare time out between them
+	if overlapping && total <= duration {
+		pack(windows, order, duration, total)
+	}
+
+	return windows
+}
+
+// bestAvoiding gets the window that overlaps placed the least, and of those the one that scores best
+func bestAvoiding(a Analysis, duration, length float64, slot Slot, placed []Window) Window {
+	var bestWindow Window
+	bestOverlap := math.Inf(1)
+	bestScore := math.Inf(-1)
+
+	for _, s := range starts(a, duration, length) {
+		w := Window{s, s + length}
+		overlap := overlapOf(w, placed)
+		score := a.Score(w)
+		if slot.Anchored {
+			score += anchorPreference(w, duration, slot.Anchor)
+		} else {
+			score += preference(w, duration)
+		}
+
+		if overlap < bestOverlap-1e-9 || (math.Abs(overlap-bestOverlap) <= 1e-9 && score > bestScore) {
+			bestWindow = w
+			bestOverlap = overlap
+			bestScore = score
+		}
+	}
+
+	return bestWindow
+}
+
+// overlapOf gets how many seconds w shares with the windows already placed
+func overlapOf(w Window, placed []Window) float64 {
+	total := 0.0
+	for _, p := range placed {
+		total += w.Overlap(p)
+	}
+
+	return total
+}
+
+// pack lays the windows out end to end in the order they start, with the spare time spread evenly around them
+func pack(windows []*Window, order []int, duration, total float64) {
+	sorted := append([]int(nil), order...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return windows[sorted[i]].Start < windows[sorted[j]].Start
+	})
+
+	gap := (duration - total) / float64(len(sorted)+1)
+	t := gap
+	for _, i := range sorted {
+		length := windows[i].Length()
+		windows[i].Start = t
+		windows[i].End = t + length
+		t += length + gap
+	}
+}
@@ -0,0 +1,59 @@
+package segment
+
+import (
+	"context"
+	"github.com/therealpenguin/takeabow-upload-processor/command"
+)
+
+// BlackFreeze avoids black frames and frozen pictures, using ffmpeg's blackdetect and freezedetect
+type BlackFreeze struct{}
+
+func (BlackFreeze) Analyze(ctx context.Context, path string, duration float64) (Analysis, error) {
+	log := &limitedBuffer{}
+	_, err := command.FFmpeg(
+		"-nostats",
+		"-i", path,
+		"-an",
+		"-filter:v", "blackdetect=d=0.1:pix_th=0.10,freezedetect=n=-60dB:d=0.5",
+		"-f", "null", "-",
+	).WithStderr(log).Run(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return blackFreezeAnalysis{parseBlackFreeze(log.Bytes(), duration)}, nil
+}
+
+type blackFreezeAnalysis struct {
+	bad []Window
+}
+
+// Score takes away every second of the window that is black or frozen
+func (b blackFreezeAnalysis) Score(w Window) float64 {
+	score := 0.0
+	for _, bad := range b.bad {
+		score -= w.Overlap(bad)
+	}
+
+	return score
+}
+
+// limitedBuffer keeps the start of ffmpeg's log for parsing, up to MaxLog, as it can be long for a long video
+type limitedBuffer struct {
+	data []byte
+}
+
+// MaxLog is the most of ffmpeg's log we keep
+const MaxLog = 8 << 20
+
+func (l *limitedBuffer) Write(p []byte) (int, error) {
+	if len(l.data) < MaxLog {
+		l.data = append(l.data, p...)
+	}
+
+	return len(p), nil
+}
+
+func (l *limitedBuffer) Bytes() []byte {
+	return l.data
+}
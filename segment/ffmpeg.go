@@ -0,0 +1,85 @@
+package segment
+
+import (
+	"bufio"
+	"bytes"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// sample is a value ffmpeg's metadata filter printed for the frame at a time
+type sample struct {
+	Time  float64
+	Value float64
+}
+
+// parseMetadata reads the output of ffmpeg's metadata=print filter, getting key's value for each frame
+func parseMetadata(output []byte, key string) []sample {
+	samples := make([]sample, 0)
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	t := -1.0
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		if strings.HasPrefix(line, "frame:") {
+			t = -1
+			for _, field := range strings.Fields(line) {
+				if strings.HasPrefix(field, "pts_time:") {
+					t, _ = strconv.ParseFloat(strings.TrimPrefix(field, "pts_time:"), 64)
+				}
+			}
+			continue
+		}
+
+		if t >= 0 && strings.HasPrefix(line, key+"=") {
+			v, err := strconv.ParseFloat(strings.TrimPrefix(line, key+"="), 64)
+			if err == nil {
+				samples = append(samples, sample{t, v})
+			}
+		}
+	}
+
+	return samples
+}
+
+var blackRegexp = regexp.MustCompile(`black_start:\s*([0-9.]+)\s+black_end:\s*([0-9.]+)`)
+var freezeStartRegexp = regexp.MustCompile(`freeze_start:\s*([0-9.]+)`)
+var freezeEndRegexp = regexp.MustCompile(`freeze_end:\s*([0-9.]+)`)
+
+// parseBlackFreeze reads the log lines blackdetect and freezedetect write, getting the ranges that are black or frozen.
+// A freeze that is still going when the video ends runs to duration
+func parseBlackFreeze(log []byte, duration float64) []Window {
+	windows := make([]Window, 0)
+	freezeStart := -1.0
+
+	scanner := bufio.NewScanner(bytes.NewReader(log))
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if m := blackRegexp.FindStringSubmatch(line); m != nil {
+			start, _ := strconv.ParseFloat(m[1], 64)
+			end, _ := strconv.ParseFloat(m[2], 64)
+			windows = append(windows, Window{start, end})
+			continue
+		}
+
+		if m := freezeStartRegexp.FindStringSubmatch(line); m != nil {
+			freezeStart, _ = strconv.ParseFloat(m[1], 64)
+			continue
+		}
+
+		if m := freezeEndRegexp.FindStringSubmatch(line); m != nil && freezeStart >= 0 {
+			end, _ := strconv.ParseFloat(m[1], 64)
+			windows = append(windows, Window{freezeStart, end})
+			freezeStart = -1
+		}
+	}
+
+	if freezeStart >= 0 {
+		windows = append(windows, Window{freezeStart, duration})
+	}
+
+	return windows
+}
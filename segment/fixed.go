@@ -0,0 +1,19 @@
+package segment
+
+import (
+	"context"
+)
+
+// Fixed starts every window DefaultFraction of the way through the video, or at the beginning if it doesn't fit there.
+// It has no opinion of its own about windows, so they all land where they are preferred to be
+type Fixed struct{}
+
+func (Fixed) Analyze(ctx context.Context, path string, duration float64) (Analysis, error) {
+	return fixedAnalysis{}, nil
+}
+
+type fixedAnalysis struct{}
+
+func (fixedAnalysis) Score(w Window) float64 {
+	return 0
+}
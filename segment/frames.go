@@ -0,0 +1,27 @@
+package segment
+
+import "math"
+
+// PosterLength is the length of the window a poster frame is picked from
+const PosterLength = 1.0
+
+// Poster gets the time of the best frame to show for a video, which is the start of the best short window in it,
+// picked the same way a slot's start is
+func Poster(a Analysis, duration float64) float64 {
+	w, err := Best(a, duration, math.Min(PosterLength, duration))
+	if err != nil {
+		return 0
+	}
+
+	return w.Start
+}
+
+// Spread gets n times evenly spaced through a video, each in the middle of its share of it
+func Spread(duration float64, n int) []float64 {
+	times := make([]float64, n)
+	for i := range times {
+		times[i] = duration * (float64(i) + 0.5) / float64(n)
+	}
+
+	return times
+}
@@ -0,0 +1,61 @@
+package segment
+
+import (
+	"context"
+	"github.com/therealpenguin/takeabow-upload-processor/command"
+	"sort"
+)
+
+// MotionFPS is how many frames a second are sampled for motion
+const MotionFPS = "4"
+
+// Motion prefers the windows with the most going on, measured by how much each frame differs from the last
+type Motion struct{}
+
+func (Motion) Analyze(ctx context.Context, path string, duration float64) (Analysis, error) {
+	output, err := command.FFmpeg(
+		"-i", path,
+		"-an",
+		"-filter:v", "fps="+MotionFPS+",scale=160:-2,signalstats,metadata=print:key=lavfi.signalstats.YDIF:file=-",
+		"-f", "null", "-",
+	).Run(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return newMotionAnalysis(parseMetadata(output, "lavfi.signalstats.YDIF")), nil
+}
+
+// motionAnalysis keeps running totals of the samples, so any window can be scored without adding them all up again
+type motionAnalysis struct {
+	times  []float64
+	totals []float64
+}
+
+func newMotionAnalysis(samples []sample) motionAnalysis {
+	sort.Slice(samples, func(i, j int) bool {
+		return samples[i].Time < samples[j].Time
+	})
+
+	m := motionAnalysis{
+		times:  make([]float64, len(samples)),
+		totals: make([]float64, len(samples)+1),
+	}
+	for i, s := range samples {
+		m.times[i] = s.Time
+		m.totals[i+1] = m.totals[i] + s.Value
+	}
+
+	return m
+}
+
+// Score is the average difference between frames inside the window
+func (m motionAnalysis) Score(w Window) float64 {
+	from := sort.SearchFloat64s(m.times, w.Start)
+	to := sort.SearchFloat64s(m.times, w.End)
+	if to <= from {
+		return 0
+	}
+
+	return (m.totals[to] - m.totals[from]) / float64(to-from)
+}
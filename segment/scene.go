@@ -0,0 +1,63 @@
+package segment
+
+import (
+	"context"
+	"fmt"
+	"github.com/therealpenguin/takeabow-upload-processor/command"
+)
+
+// DefaultSceneThreshold is how different a frame must be from the last one to count as a cut
+const DefaultSceneThreshold = 0.3
+
+// CutTolerance is how soon, in seconds, after a cut a window has to start to count as starting on it
+const CutTolerance = 0.25
+
+// Scene prefers windows that start on a cut and then stay on one shot, so a slot looks like a deliberate edit
+type Scene struct {
+	Threshold float64
+}
+
+func (s Scene) Analyze(ctx context.Context, path string, duration float64) (Analysis, error) {
+	output, err := command.FFmpeg(
+		"-i", path,
+		"-an",
+		"-filter:v", fmt.Sprintf("select='gt(scene,%.2f)',metadata=print:file=-", s.Threshold),
+		"-f", "null", "-",
+	).Run(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return sceneAnalysis{parseMetadata(output, "lavfi.scene_score")}, nil
+}
+
+type sceneAnalysis struct {
+	cuts []sample
+}
+
+// Score gives a point for starting on a cut, less the further after it the window starts, and takes away the strength of every cut inside the window
+func (s sceneAnalysis) Score(w Window) float64 {
+	score := 0.0
+	for _, c := range s.cuts {
+		if c.Time <= w.Start && w.Start-c.Time <= CutTolerance {
+			score += 1 - (w.Start - c.Time)
+			continue
+		}
+
+		if c.Time > w.Start && c.Time < w.End {
+			score -= c.Value
+		}
+	}
+
+	return score
+}
+
+// Candidates gets the cuts, which are the best places to start
+func (s sceneAnalysis) Candidates() []float64 {
+	times := make([]float64, 0, len(s.cuts))
+	for _, c := range s.cuts {
+		times = append(times, c.Time)
+	}
+
+	return times
+}
@@ -0,0 +1,142 @@
+package segment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// ErrTooShort means a video is shorter than the window we want from it
+var ErrTooShort = errors.New("Video is too short")
+
+// Resolution is the spacing, in seconds, of the start times Best tries
+const Resolution = 0.25
+
+// MaxStarts is the most grid start times tried for one window
+const MaxStarts = 4000
+
+// DefaultFraction is how far through a video the fixed strategy starts, and what every strategy prefers when windows score the same
+const DefaultFraction = 0.4
+
+// Window is a range of a video, in seconds
+type Window struct {
+	Start float64
+	End   float64
+}
+
+// Length gets how long the window is
+func (w Window) Length() float64 {
+	return w.End - w.Start
+}
+
+// Overlap gets how many seconds two windows share
+func (w Window) Overlap(o Window) float64 {
+	return math.Max(0, math.Min(w.End, o.End)-math.Max(w.Start, o.Start))
+}
+
+func (w Window) String() string {
+	return fmt.Sprintf("%.3f-%.3f", w.Start, w.End)
+}
+
+// Analysis rates windows of one video. Higher scores are better
+type Analysis interface {
+	Score(w Window) float64
+}
+
+// Candidates is implemented by analyses that know of particular start times worth trying, such as scene cuts
+type Candidates interface {
+	Candidates() []float64
+}
+
+// Strategy analyses a video so the best windows for its slots can be picked
+type Strategy interface {
+	Analyze(ctx context.Context, path string, duration float64) (Analysis, error)
+}
+
+// Best gets the highest scoring window of length seconds in a video of duration seconds
+func Best(a Analysis, duration, length float64) (Window, error) {
+	if length > duration {
+		return Window{}, ErrTooShort
+	}
+
+	var bestWindow Window
+	bestScore := math.Inf(-1)
+	for _, s := range starts(a, duration, length) {
+		w := Window{s, s + length}
+		score := a.Score(w) + preference(w, duration)
+
+		if score > bestScore {
+			bestScore = score
+			bestWindow = w
+		}
+	}
+
+	return bestWindow, nil
+}
+
+// starts gets the start times worth trying for a window of length: a grid over the video, plus any the analysis suggests.
+// Long videos get a coarser grid, so there are never more than MaxStarts on it
+func starts(a Analysis, duration, length float64) []float64 {
+	step := math.Max(Resolution, duration/MaxStarts)
+
+	starts := []float64{0, duration - length}
+	for s := step; s < duration-length; s += step {
+		starts = append(starts, s)
+	}
+
+	if c, ok := a.(Candidates); ok {
+		for _, s := range c.Candidates() {
+			if s >= 0 && s <= duration-length {
+				starts = append(starts, s)
+			}
+		}
+	}
+
+	return starts
+}
+
+// preference is a tiny nudge towards DefaultFraction of the way through, so equally good windows are picked the way we always have
+func preference(w Window, duration float64) float64 {
+	return -1e-6 * math.Abs(w.Start-target(duration, w.Length()))
+}
+
+// AnchorWeight is how much score a window loses for starting the whole length of the video away from its slot's anchor
+const AnchorWeight = 1.0
+
+// anchorPreference pulls a window towards starting anchor of the way through the video, or as close as it fits
+func anchorPreference(w Window, duration, anchor float64) float64 {
+	start := math.Min(anchor*duration, duration-w.Length())
+
+	return -AnchorWeight * math.Abs(w.Start-start) / duration
+}
+
+// target is where the fixed strategy starts a window: DefaultFraction through the video, or the beginning if it doesn't fit there
+func target(duration, length float64) float64 {
+	if duration*DefaultFraction+length <= duration {
+		return duration * DefaultFraction
+	}
+
+	return 0
+}
+
+// New gets the strategy with the given name
+func New(name string) (Strategy, error) {
+	switch name {
+	case "", NameFixed:
+		return Fixed{}, nil
+	case NameScene:
+		return Scene{Threshold: DefaultSceneThreshold}, nil
+	case NameBlackFreeze:
+		return BlackFreeze{}, nil
+	case NameMotion:
+		return Motion{}, nil
+	}
+
+	return nil, fmt.Errorf("Unknown split strategy %q", name)
+}
+
+const NameFixed = "fixed"
+const NameScene = "scene"
+const NameBlackFreeze = "blackfreeze"
+const NameMotion = "motion"
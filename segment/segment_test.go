@@ -0,0 +1,162 @@
+package segment
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestFixed(t *testing.T) {
+	a, err := Fixed{}.Analyze(context.Background(), "", 100)
+	assert.Nil(t, err)
+
+	// 40% of the way through when it fits
+	w, err := Best(a, 100, 5.8)
+	assert.Nil(t, err)
+	assert.InDelta(t, 40, w.Start, 1e-9)
+	assert.InDelta(t, 45.8, w.End, 1e-9)
+
+	// From the beginning when it doesn't
+	w, err = Best(a, 100, 80)
+	assert.Nil(t, err)
+	assert.Equal(t, 0.0, w.Start)
+
+	_, err = Best(a, 100, 101)
+	assert.Equal(t, ErrTooShort, err)
+}
+
+func TestBlackFreeze(t *testing.T) {
+	log := []byte(`[blackdetect @ 0x5581] black_start:0 black_end:3.5 black_duration:3.5
+[freezedetect @ 0x5582] lavfi.freezedetect.freeze_start: 38.2
+[freezedetect @ 0x5582] lavfi.freezedetect.freeze_duration: 10
+[freezedetect @ 0x5582] lavfi.freezedetect.freeze_end: 48.2
+[freezedetect @ 0x5582] lavfi.freezedetect.freeze_start: 95
+`)
+	bad := parseBlackFreeze(log, 100)
+	assert.Equal(t, []Window{{0, 3.5}, {38.2, 48.2}, {95, 100}}, bad)
+
+	// 40% would land in the freeze, so the nearest clean window is picked
+	w, err := Best(blackFreezeAnalysis{bad}, 100, 5)
+	assert.Nil(t, err)
+	assert.Equal(t, 0.0, w.Overlap(Window{38.2, 48.2}))
+	assert.InDelta(t, 33.2, w.Start, Resolution)
+}
+
+func TestScene(t *testing.T) {
+	output := []byte(`frame:0    pts:120     pts_time:10.2
+lavfi.scene_score=0.45
+frame:1    pts:300     pts_time:25.1
+lavfi.scene_score=0.9
+frame:2    pts:330     pts_time:27.5
+lavfi.scene_score=0.6
+`)
+	cuts := parseMetadata(output, "lavfi.scene_score")
+	assert.Equal(t, []sample{{10.2, 0.45}, {25.1, 0.9}, {27.5, 0.6}}, cuts)
+
+	// Starting on the cut at 10.2 beats the usual 12s, and the later cuts are too near the end
+	w, err := Best(sceneAnalysis{cuts}, 30, 10)
+	assert.Nil(t, err)
+	assert.Equal(t, 10.2, w.Start)
+}
+
+func TestMotion(t *testing.T) {
+	samples := []sample{}
+	for i := 0; i < 400; i++ {
+		v := 1.0
+		if i >= 300 && i < 320 {
+			v = 20
+		}
+		samples = append(samples, sample{float64(i) * 0.25, v})
+	}
+
+	w, err := Best(newMotionAnalysis(samples), 100, 5)
+	assert.Nil(t, err)
+	assert.InDelta(t, 75, w.Start, Resolution)
+}
+
+func TestNew(t *testing.T) {
+	for _, name := range []string{"", NameFixed, NameScene, NameBlackFreeze, NameMotion} {
+		_, err := New(name)
+		assert.Nil(t, err, name)
+	}
+
+	_, err := New("random")
+	assert.NotNil(t, err)
+}
+
+func TestAllocateDistinct(t *testing.T) {
+	a, _ := Fixed{}.Analyze(context.Background(), "", 100)
+	lengths := []float64{5.8, 11.96, 6.04, 2.84, 3.24, 120}
+
+	windows := Allocate(a, 100, slotsOf(lengths))
+
+	assert.Nil(t, windows[5])
+	for i := 0; i < 5; i++ {
+		w := windows[i]
+		assert.NotNil(t, w)
+		assert.InDelta(t, lengths[i], w.Length(), 1e-9)
+		assert.True(t, w.Start >= 0 && w.End <= 100)
+
+		for j := 0; j < i; j++ {
+			assert.Equal(t, 0.0, w.Overlap(*windows[j]), "slots %d and %d overlap", i, j)
+		}
+	}
+
+	// The longest gets the spot the fixed strategy would have given it
+	assert.InDelta(t, 40, windows[1].Start, 1e-9)
+}
+
+func TestAllocatePacksWhenGreedyLeavesGaps(t *testing.T) {
+	a, _ := Fixed{}.Analyze(context.Background(), "", 10)
+	windows := Allocate(a, 10, slotsOf([]float64{4, 3, 3}))
+
+	total := 0.0
+	for i, w := range windows {
+		assert.NotNil(t, w)
+		for j := 0; j < i; j++ {
+			total += w.Overlap(*windows[j])
+		}
+	}
+	assert.Equal(t, 0.0, total)
+}
+
+func TestAllocateMinimisesOverlap(t *testing.T) {
+	a, _ := Fixed{}.Analyze(context.Background(), "", 10)
+	windows := Allocate(a, 10, slotsOf([]float64{6, 6}))
+
+	// 12 seconds of slots in a 10 second video can't overlap by less than 2
+	assert.InDelta(t, 2, windows[0].Overlap(*windows[1]), 1e-9)
+}
+
+func TestAllocateAnchorAndMinLength(t *testing.T) {
+	a, _ := Fixed{}.Analyze(context.Background(), "", 100)
+	slots := []Slot{
+		{Length: 10, Anchor: 0.9, Anchored: true},
+		{Length: 150, MinLength: 50},
+		{Length: 150},
+	}
+
+	windows := Allocate(a, 100, slots)
+
+	assert.InDelta(t, 90, windows[0].Start, 1e-9)
+	assert.InDelta(t, 100, windows[1].Length(), 1e-9)
+	assert.Nil(t, windows[2])
+}
+
+func slotsOf(lengths []float64) []Slot {
+	slots := make([]Slot, len(lengths))
+	for i, l := range lengths {
+		slots[i] = Slot{Length: l}
+	}
+
+	return slots
+}
+
+func TestPosterAndSpread(t *testing.T) {
+	a, _ := Fixed{}.Analyze(context.Background(), "", 100)
+	assert.InDelta(t, 40, Poster(a, 100), 1e-9)
+	assert.Equal(t, 0.0, Poster(a, 0.5))
+
+	assert.Equal(t, []float64{12.5, 37.5, 62.5, 87.5}, Spread(100, 4))
+	assert.Empty(t, Spread(100, 0))
+}
@@ -0,0 +1,164 @@
+package slots
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"gopkg.in/redis.v5"
+)
+
+// DefaultNamespace is what every slot set's key starts with, unless another namespace is configured
+const DefaultNamespace = "bow"
+
+// Config is how to connect to the Redis the slot sets live in
+type Config struct {
+	Addr      string
+	Password  string
+	DB        int
+	TLS       bool
+	Namespace string
+}
+
+// Dial connects to Redis and checks it answers
+func Dial(c Config) (*redis.Client, error) {
+	if c.Addr == "" {
+		return nil, errors.New("There is no Redis address")
+	}
+
+	options := &redis.Options{
+		Addr:     c.Addr,
+		Password: c.Password,
+		DB:       c.DB,
+	}
+	if c.TLS {
+		options.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+
+	client := redis.NewClient(options)
+
+	err := client.Ping().Err()
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	return client, nil
+}
+
+// Registry keeps the clips cut for each slot of each timeline in a Redis set per slot
+type Registry struct {
+	client    *redis.Client
+	namespace string
+}
+
+// New creates a Registry that keeps its sets under namespace
+func New(client *redis.Client, namespace string) *Registry {
+	if namespace == "" {
+		namespace = DefaultNamespace
+	}
+
+	return &Registry{
+		client:    client,
+		namespace: namespace,
+	}
+}
+
+// Key gets the name of a slot's set, like "bow:slots:default:3"
+func (r *Registry) Key(timeline string, slot int) string {
+	return fmt.Sprintf("%s:slots:%s:%d", r.namespace, timeline, slot)
+}
+
+// Add adds a clip to a slot
+func (r *Registry) Add(timeline string, slot int, clip string) error {
+	return r.client.SAdd(r.Key(timeline, slot), clip).Err()
+}
+
+// Remove takes a clip out of a slot
+func (r *Registry) Remove(timeline string, slot int, clip string) error {
+	return r.client.SRem(r.Key(timeline, slot), clip).Err()
+}
+
+// List gets every clip in a slot
+func (r *Registry) List(timeline string, slot int) ([]string, error) {
+	return r.client.SMembers(r.Key(timeline, slot)).Result()
+}
+
+// Count gets how many clips are in a slot
+func (r *Registry) Count(timeline string, slot int) (int64, error) {
+	return r.client.SCard(r.Key(timeline, slot)).Result()
+}
+
+// Counts gets how many clips are in each of the first n slots of a timeline
+func (r *Registry) Counts(timeline string, n int) ([]int64, error) {
+	cmds := make([]*redis.IntCmd, n)
+	_, err := r.client.Pipelined(func(p *redis.Pipeline) error {
+		for slot := 0; slot < n; slot++ {
+			cmds[slot] = p.SCard(r.Key(timeline, slot))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make([]int64, n)
+	for slot, cmd := range cmds {
+		counts[slot] = cmd.Val()
+	}
+
+	return counts, nil
+}
+
+// Underfilled gets which of the first n slots of a timeline have fewer than min clips to choose from
+func (r *Registry) Underfilled(timeline string, n int, min int64) ([]int, error) {
+	counts, err := r.Counts(timeline, n)
+	if err != nil {
+		return nil, err
+	}
+
+	underfilled := make([]int, 0)
+	for slot, count := range counts {
+		if count < min {
+			underfilled = append(underfilled, slot)
+		}
+	}
+
+	return underfilled, nil
+}
+
+// CharKey is the name the first version gave a slot's set: string(slot), a single character rather than the number
+func CharKey(slot int) string {
+	return string(rune(slot))
+}
+
+// Migrate moves the clips in the sets the first version kept, named with CharKey, into the first n slots of a timeline.
+// The old sets are deleted once they are moved. It returns how many old sets it moved
+func (r *Registry) Migrate(timeline string, n int) (int, error) {
+	moved := 0
+	for slot := 0; slot < n; slot++ {
+		key := r.Key(timeline, slot)
+		old := CharKey(slot)
+
+		exists, err := r.client.Exists(old).Result()
+		if err != nil {
+			return moved, err
+		}
+		if !exists {
+			continue
+		}
+
+		// Merge rather than rename, so clips already in the new set are kept
+		_, err = r.client.TxPipelined(func(p *redis.Pipeline) error {
+			p.SUnionStore(key, key, old)
+			p.Del(old)
+			return nil
+		})
+		if err != nil {
+			return moved, fmt.Errorf("Error moving %q to %s: %w", old, key, err)
+		}
+
+		moved++
+	}
+
+	return moved, nil
+}
@@ -0,0 +1,113 @@
+package slots
+
+import (
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/redis.v5"
+	"testing"
+)
+
+func newTestRegistry(t *testing.T) (*Registry, *miniredis.Miniredis) {
+	m := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: m.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	return New(client, ""), m
+}
+
+func TestKey(t *testing.T) {
+	r := New(nil, "")
+	assert.Equal(t, "bow:slots:default:3", r.Key("default", 3))
+
+	r = New(nil, "staging")
+	assert.Equal(t, "staging:slots:waltz:12", r.Key("waltz", 12))
+}
+
+func TestCharKey(t *testing.T) {
+	assert.Equal(t, "\x03", CharKey(3))
+}
+
+func TestAddAndCount(t *testing.T) {
+	r, m := newTestRegistry(t)
+
+	assert.NoError(t, r.Add("default", 1, "split/default/1/a.mp4"))
+	assert.NoError(t, r.Add("default", 1, "split/default/1/b.mp4"))
+	// A clip is only in a slot once
+	assert.NoError(t, r.Add("default", 1, "split/default/1/a.mp4"))
+	assert.NoError(t, r.Add("waltz", 1, "split/waltz/1/a.mp4"))
+
+	count, err := r.Count("default", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), count)
+
+	members, err := m.Members("bow:slots:default:1")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"split/default/1/a.mp4", "split/default/1/b.mp4"}, members)
+
+	count, err = r.Count("default", 0)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), count)
+
+	assert.NoError(t, r.Remove("default", 1, "split/default/1/a.mp4"))
+	clips, err := r.List("default", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"split/default/1/b.mp4"}, clips)
+}
+
+func TestUnderfilled(t *testing.T) {
+	r, _ := newTestRegistry(t)
+
+	for _, clip := range []string{"a", "b"} {
+		assert.NoError(t, r.Add("default", 0, clip))
+		assert.NoError(t, r.Add("default", 2, clip))
+	}
+	assert.NoError(t, r.Add("default", 1, "a"))
+
+	counts, err := r.Counts("default", 4)
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{2, 1, 2, 0}, counts)
+
+	underfilled, err := r.Underfilled("default", 4, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{1, 3}, underfilled)
+
+	underfilled, err = r.Underfilled("default", 4, 0)
+	assert.NoError(t, err)
+	assert.Empty(t, underfilled)
+}
+
+func TestMigrate(t *testing.T) {
+	r, m := newTestRegistry(t)
+
+	// Slot 0 only has an old set, slot 1 has both and slot 2 only has a new one
+	m.SAdd(CharKey(0), "split/0/a.mp4")
+	m.SAdd(CharKey(1), "split/1/a.mp4", "split/1/b.mp4")
+	assert.NoError(t, r.Add("default", 1, "split/default/1/c.mp4"))
+	assert.NoError(t, r.Add("default", 1, "split/1/a.mp4"))
+	assert.NoError(t, r.Add("default", 2, "split/default/2/a.mp4"))
+
+	moved, err := r.Migrate("default", 3)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, moved)
+
+	clips, err := r.List("default", 0)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"split/0/a.mp4"}, clips)
+
+	// The old and new sets are merged, keeping what was already in the new one
+	clips, err = r.List("default", 1)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"split/1/a.mp4", "split/1/b.mp4", "split/default/1/c.mp4"}, clips)
+
+	clips, err = r.List("default", 2)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"split/default/2/a.mp4"}, clips)
+
+	assert.False(t, m.Exists(CharKey(0)))
+	assert.False(t, m.Exists(CharKey(1)))
+
+	// There's nothing left to move the second time
+	moved, err = r.Migrate("default", 3)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, moved)
+}
@@ -0,0 +1,79 @@
+package steps
+
+import (
+	"fmt"
+	"gopkg.in/redis.v5"
+	"time"
+)
+
+// TTL is how long a video's steps are kept after the last one is done, in case its message is redelivered
+const TTL = 7 * 24 * time.Hour
+
+const StepThumbnails = "thumbnails"
+
+// Rendered is the step of rendering a profile and uploading it
+func Rendered(profile string) string {
+	return "rendered:" + profile
+}
+
+// Split is the step of cutting a slot of a version of a timeline and uploading it. A slot cut against another
+// version is a different step, so it's cut again
+func Split(timeline, version string, slot int) string {
+	return fmt.Sprintf("split:%s@%s:%d", timeline, version, slot)
+}
+
+// Done is the steps of processing one video that are done, and what each of them left behind, such as the key it uploaded
+type Done map[string]string
+
+// Has reports whether a step is done, and what it left behind
+func (d Done) Has(step string) (string, bool) {
+	value, ok := d[step]
+	return value, ok
+}
+
+// Tracker records the steps of processing each video in a Redis hash, so a video whose message is redelivered
+// after a crash can carry on from where it got to
+type Tracker struct {
+	client    *redis.Client
+	namespace string
+}
+
+// New creates a Tracker that keeps its hashes under namespace
+func New(client *redis.Client, namespace string) *Tracker {
+	return &Tracker{
+		client:    client,
+		namespace: namespace,
+	}
+}
+
+// Key gets the name of a video's hash, like "bow:steps:123"
+func (t *Tracker) Key(id string) string {
+	return fmt.Sprintf("%s:steps:%s", t.namespace, id)
+}
+
+// Load gets the steps done for a video
+func (t *Tracker) Load(id string) (Done, error) {
+	done, err := t.client.HGetAll(t.Key(id)).Result()
+	if err != nil {
+		return Done{}, err
+	}
+
+	return Done(done), nil
+}
+
+// Mark records that a step is done for a video
+func (t *Tracker) Mark(id, step, value string) error {
+	key := t.Key(id)
+	_, err := t.client.Pipelined(func(p *redis.Pipeline) error {
+		p.HSet(key, step, value)
+		p.Expire(key, TTL)
+		return nil
+	})
+
+	return err
+}
+
+// Clear forgets every step done for a video, once it's finished
+func (t *Tracker) Clear(id string) error {
+	return t.client.Del(t.Key(id)).Err()
+}
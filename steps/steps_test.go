@@ -0,0 +1,23 @@
+package steps
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestNames(t *testing.T) {
+	assert.Equal(t, "bow:steps:123", New(nil, "bow").Key("123"))
+	assert.Equal(t, "rendered:small", Rendered("small"))
+	assert.Equal(t, "split:default@1:3", Split("default", "1", 3))
+
+	done := Done{Split("default", "1", 3): "split/default/3/123.mp4"}
+	key, ok := done.Has(Split("default", "1", 3))
+	assert.True(t, ok)
+	assert.Equal(t, "split/default/3/123.mp4", key)
+
+	_, ok = done.Has(Split("default", "2", 3))
+	assert.False(t, ok)
+
+	_, ok = done.Has(Rendered("small"))
+	assert.False(t, ok)
+}
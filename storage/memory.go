@@ -0,0 +1,110 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Memory keeps objects in memory. It is meant for tests
+type Memory struct {
+	mu      sync.RWMutex
+	objects map[string]memoryObject
+}
+
+type memoryObject struct {
+	data         []byte
+	lastModified time.Time
+}
+
+func NewMemory() *Memory {
+	return &Memory{
+		objects: make(map[string]memoryObject),
+	}
+}
+
+func (m *Memory) Head(ctx context.Context, key string) (*Object, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	o, ok := m.objects[key]
+	if !ok {
+		return nil, ErrNotExist
+	}
+
+	return &Object{
+		Key:          key,
+		Size:         int64(len(o.data)),
+		LastModified: o.lastModified,
+	}, nil
+}
+
+func (m *Memory) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	o, ok := m.objects[key]
+	if !ok {
+		return nil, ErrNotExist
+	}
+
+	return ioutil.NopCloser(bytes.NewReader(o.data)), nil
+}
+
+func (m *Memory) Put(ctx context.Context, key string, r io.Reader) error {
+	data, err := ioutil.ReadAll(&contextReader{ctx, r})
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.objects[key] = memoryObject{
+		data:         data,
+		lastModified: time.Now(),
+	}
+
+	return nil
+}
+
+func (m *Memory) Delete(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.objects, key)
+
+	return nil
+}
+
+func (m *Memory) List(ctx context.Context, prefix string) ([]Object, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	objects := make([]Object, 0)
+	for key, o := range m.objects {
+		if strings.HasPrefix(key, prefix) {
+			objects = append(objects, Object{
+				Key:          key,
+				Size:         int64(len(o.data)),
+				LastModified: o.lastModified,
+			})
+		}
+	}
+
+	sort.Slice(objects, func(i, j int) bool {
+		return objects[i].Key < objects[j].Key
+	})
+
+	return objects, nil
+}
+
+// String describes where the objects are kept
+func (m *Memory) String() string {
+	return "memory://"
+}
@@ -0,0 +1,85 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+)
+
+// ErrNotExist is returned when a key has no object
+var ErrNotExist = errors.New("Object does not exist")
+
+// Object describes something held in a Backend
+type Object struct {
+	Key          string
+	Size         int64
+	LastModified time.Time
+}
+
+// Backend is somewhere we can read source videos from and write processed videos to
+type Backend interface {
+	// Head gets an object's details, or ErrNotExist
+	Head(ctx context.Context, key string) (*Object, error)
+	// Get opens an object for reading, or returns ErrNotExist. The caller must close it
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Put writes everything in r to key, replacing what was there
+	Put(ctx context.Context, key string, r io.Reader) error
+	// Delete removes an object. Deleting a key that doesn't exist is not an error
+	Delete(ctx context.Context, key string) error
+	// List gets every object whose key starts with prefix
+	List(ctx context.Context, prefix string) ([]Object, error)
+}
+
+// CleanKey turns a URL path or user supplied key into a relative, slash separated key that can't escape its root
+func CleanKey(key string) string {
+	return strings.TrimPrefix(path.Clean("/"+key), "/")
+}
+
+// CleanPrefix cleans a prefix like CleanKey, keeping a trailing slash so "a/" still doesn't match "ab"
+func CleanPrefix(prefix string) string {
+	clean := CleanKey(prefix)
+	if clean != "" && strings.HasSuffix(prefix, "/") {
+		clean += "/"
+	}
+
+	return clean
+}
+
+// Clean wraps a backend so every key it's given goes through CleanKey first. Every backend is used through it,
+// so a key names the same object whichever backend it's kept in
+func Clean(b Backend) Backend {
+	return cleanBackend{b}
+}
+
+type cleanBackend struct {
+	backend Backend
+}
+
+func (c cleanBackend) Head(ctx context.Context, key string) (*Object, error) {
+	return c.backend.Head(ctx, CleanKey(key))
+}
+
+func (c cleanBackend) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	return c.backend.Get(ctx, CleanKey(key))
+}
+
+func (c cleanBackend) Put(ctx context.Context, key string, r io.Reader) error {
+	return c.backend.Put(ctx, CleanKey(key), r)
+}
+
+func (c cleanBackend) Delete(ctx context.Context, key string) error {
+	return c.backend.Delete(ctx, CleanKey(key))
+}
+
+func (c cleanBackend) List(ctx context.Context, prefix string) ([]Object, error) {
+	return c.backend.List(ctx, CleanPrefix(prefix))
+}
+
+// String describes the backend it wraps
+func (c cleanBackend) String() string {
+	return fmt.Sprint(c.backend)
+}
@@ -0,0 +1,94 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func testBackend(t *testing.T, b Backend) {
+	ctx := context.Background()
+
+	_, err := b.Head(ctx, "processed/foo.mp4")
+	assert.Equal(t, ErrNotExist, err)
+
+	_, err = b.Get(ctx, "processed/foo.mp4")
+	assert.Equal(t, ErrNotExist, err)
+
+	err = b.Put(ctx, "processed/foo.mp4", bytes.NewBufferString("video"))
+	assert.Nil(t, err)
+	err = b.Put(ctx, "small/foo.mp4", bytes.NewBufferString("small"))
+	assert.Nil(t, err)
+
+	o, err := b.Head(ctx, "processed/foo.mp4")
+	assert.Nil(t, err)
+	assert.Equal(t, "processed/foo.mp4", o.Key)
+	assert.Equal(t, int64(5), o.Size)
+
+	r, err := b.Get(ctx, "processed/foo.mp4")
+	assert.Nil(t, err)
+	data, err := ioutil.ReadAll(r)
+	r.Close()
+	assert.Nil(t, err)
+	assert.Equal(t, "video", string(data))
+
+	objects, err := b.List(ctx, "processed/")
+	assert.Nil(t, err)
+	assert.Len(t, objects, 1)
+	assert.Equal(t, "processed/foo.mp4", objects[0].Key)
+
+	err = b.Delete(ctx, "processed/foo.mp4")
+	assert.Nil(t, err)
+	err = b.Delete(ctx, "processed/foo.mp4")
+	assert.Nil(t, err)
+
+	_, err = b.Head(ctx, "processed/foo.mp4")
+	assert.Equal(t, ErrNotExist, err)
+}
+
+func TestMemory(t *testing.T) {
+	testBackend(t, NewMemory())
+}
+
+func TestLocal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "storage")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	testBackend(t, NewLocal(dir))
+}
+
+func TestCleanKey(t *testing.T) {
+	assert.Equal(t, "upload/foo.mp4", CleanKey("/upload/foo.mp4"))
+	assert.Equal(t, "foo.mp4", CleanKey("../../foo.mp4"))
+	assert.Equal(t, "upload/foo.mp4", CleanKey("upload//bar/../foo.mp4"))
+}
+
+func TestClean(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory()
+	b := Clean(m)
+
+	testBackend(t, b)
+
+	err := b.Put(ctx, "/a//b/../c.mp4", bytes.NewBufferString("video"))
+	assert.Nil(t, err)
+
+	// Every spelling of a key names the same object
+	_, err = m.Head(ctx, "a/c.mp4")
+	assert.Nil(t, err)
+	_, err = b.Head(ctx, "a/c.mp4")
+	assert.Nil(t, err)
+
+	err = b.Put(ctx, "ab/d.mp4", bytes.NewBufferString("video"))
+	assert.Nil(t, err)
+
+	objects, err := b.List(ctx, "/a/")
+	assert.Nil(t, err)
+	assert.Len(t, objects, 1)
+	assert.Equal(t, "memory://", fmt.Sprint(b))
+}
@@ -0,0 +1,117 @@
+package timecode
+
+import (
+	"context"
+	"github.com/fsnotify/fsnotify"
+	"github.com/therealpenguin/takeabow-upload-processor/logging"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+// ReloadDelay is how long a watched path has to stay unchanged before it is reloaded, so a file is read once it's fully written
+const ReloadDelay = time.Second
+
+// Store holds the current timelines. They can be swapped for new ones at any time; a job that has already
+// got the timelines keeps the ones it got
+type Store struct {
+	current atomic.Pointer[Timelines]
+}
+
+// NewStore makes a Store holding t
+func NewStore(t *Timelines) *Store {
+	s := &Store{}
+	s.current.Store(t)
+
+	return s
+}
+
+// Load gets the current timelines
+func (s *Store) Load() *Timelines {
+	return s.current.Load()
+}
+
+// Swap replaces the current timelines with t
+func (s *Store) Swap(t *Timelines) {
+	s.current.Store(t)
+}
+
+// Watch reloads the timelines with load whenever path, a timeline file or a directory of them, changes.
+// New timelines are only swapped in if they load and are a different version; otherwise the old ones are kept.
+// It runs until ctx is cancelled, and only returns an error if path can't be watched
+func (s *Store) Watch(ctx context.Context, path string, load func() (*Timelines, error)) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+
+	defer watcher.Close()
+
+	// Watch the directory a file is in rather than the file, so it's still watched after an editor or
+	// a Kubernetes config map replaces it
+	dir, name := path, ""
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		dir, name = filepath.Dir(path), filepath.Base(path)
+	}
+
+	err = watcher.Add(dir)
+	if err != nil {
+		return err
+	}
+
+	timer := time.NewTimer(ReloadDelay)
+	timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+
+			if name == "" || filepath.Base(event.Name) == name || isConfigMapSwap(event.Name) {
+				timer.Reset(ReloadDelay)
+			}
+
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			slog.Warn("Error watching timelines", "path", path, logging.Err(err))
+
+		case <-timer.C:
+			s.reload(load)
+		}
+	}
+}
+
+// reload swaps in the timelines load gets, if they are new
+func (s *Store) reload(load func() (*Timelines, error)) {
+	t, err := load()
+	if err != nil {
+		slog.Error("Couldn't reload timelines, keeping the old ones", "version", s.Load().Version, logging.Err(err))
+		return
+	}
+
+	old := s.Load()
+	if t.Version == old.Version {
+		return
+	}
+
+	s.Swap(t)
+	slog.Info("Reloaded timelines", "timelines", t.Names(), "version", t.Version, "old_version", old.Version)
+}
+
+// isConfigMapSwap reports whether an event is Kubernetes swapping in a new version of a mounted config map
+func isConfigMapSwap(name string) bool {
+	return filepath.Base(name) == "..data"
+}
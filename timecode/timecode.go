@@ -1,41 +1,373 @@
 package timecode
 
 import (
+	"bytes"
+	"crypto/sha256"
 	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"gopkg.in/yaml.v2"
 	"io"
+	"os"
+	"path/filepath"
+	"regexp"
 	"strconv"
+	"strings"
 )
 
-// Timecode represents a section of video that we should cut to
+// Timecode represents a section of video that we should cut to, and how to cut it
 type Timecode struct {
-	Length float64
+	// Name identifies the slot. Slots without one are known by their position
+	Name string `json:"name,omitempty" yaml:"name,omitempty"`
+
+	// Length is how long the cut is, in seconds. It defaults to MaxLength
+	Length float64 `json:"length,omitempty" yaml:"length,omitempty"`
+
+	// MinLength lets the cut be shorter than Length, down to MinLength, when the video is too short for it
+	MinLength float64 `json:"min_length,omitempty" yaml:"min_length,omitempty"`
+
+	// MaxLength is the longest the cut may be
+	MaxLength float64 `json:"max_length,omitempty" yaml:"max_length,omitempty"`
+
+	// Anchor is how far through the video, from 0 to 1, the cut would rather start
+	Anchor *float64 `json:"anchor,omitempty" yaml:"anchor,omitempty"`
+
+	// Crop is an ffmpeg crop of the cut, as width:height or width:height:x:y
+	Crop string `json:"crop,omitempty" yaml:"crop,omitempty"`
+
+	// Aspect crops the middle of the cut to an aspect ratio, such as 9:16
+	Aspect string `json:"aspect,omitempty" yaml:"aspect,omitempty"`
+}
+
+// Timeline is a set of slots a video is cut into. Its version changes whenever the file it was read from does
+type Timeline struct {
+	Name    string
+	Version string
+	Slots   []Timecode
+}
+
+// Len gets how many slots there are
+func (t *Timeline) Len() int {
+	return len(t.Slots)
+}
+
+// Error is a slot that couldn't be read. Line is where it is in a CSV file; slots in other formats are known by Slot
+type Error struct {
+	Line int
+	Slot int
+	Err  error
+}
+
+func (e *Error) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("line %d: %s", e.Line, e.Err)
+	}
+
+	return fmt.Sprintf("slot %d: %s", e.Slot, e.Err)
 }
 
-// NewFromPath uses a reader and converts the first column into a Timecode
-func NewFromFile(r io.Reader) (*[]Timecode, error) {
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+const FormatCSV = "csv"
+const FormatJSON = "json"
+const FormatYAML = "yaml"
+
+// Columns are the CSV columns, in the order they are read when a file has no header
+var Columns = []string{"length", "name", "min_length", "max_length", "anchor", "crop", "aspect"}
+
+var cropPattern = regexp.MustCompile(`^\d+:\d+(:\d+:\d+)?$`)
+var aspectPattern = regexp.MustCompile(`^(\d+)[:/](\d+)$`)
+
+// LoadFile reads a timeline from a file, in the format its extension says
+func LoadFile(path string) (*Timeline, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	t, err := Load(bytes.NewReader(b), formatOf(path))
+	if err != nil {
+		return nil, fmt.Errorf("Error reading timecodes from %s: %w", path, err)
+	}
+
+	t.Name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	t.Version = version(b)
+
+	return t, nil
+}
+
+// version is a short hash of a timeline's contents
+func version(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:6])
+}
+
+// formatOf gets the format of a timeline file from its extension
+func formatOf(path string) string {
+	format := strings.TrimPrefix(strings.ToLower(filepath.Ext(path)), ".")
+	if format == "yml" {
+		return FormatYAML
+	}
+
+	return format
+}
+
+// Load reads a timeline in format, which is one of FormatCSV, FormatJSON or FormatYAML
+func Load(r io.Reader, format string) (*Timeline, error) {
+	switch format {
+	case FormatCSV:
+		return ReadCSV(r)
+	case FormatJSON:
+		return ReadJSON(r)
+	case FormatYAML:
+		return ReadYAML(r)
+	}
+
+	return nil, fmt.Errorf("Unknown timecode format %q", format)
+}
+
+// ReadCSV reads a timeline from CSV. If the first row is a header, the columns can come in any order and any of
+// them but length or max_length can be left out. Otherwise the columns are read in the order of Columns,
+// so a file of just lengths is still valid. Lines starting with # are ignored
+func ReadCSV(r io.Reader) (*Timeline, error) {
 	reader := csv.NewReader(r)
-	timecodes := make([]Timecode, 0)
-	row := 0
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	reader.Comment = '#'
 
-	for {
-		record, err := reader.Read()
+	columns := Columns
+	slots := make([]Timecode, 0)
 
+	for row := 0; ; row++ {
+		record, err := reader.Read()
 		if err == io.EOF {
 			break
 		} else if err != nil {
 			return nil, err
 		}
 
-		if len(record) < 1 {
-			return nil, fmt.Errorf("Row %d of timecode csv is empty", row)
+		line, _ := reader.FieldPos(0)
+
+		if row == 0 && isHeader(record) {
+			columns, err = header(record)
+			if err != nil {
+				return nil, &Error{Line: line, Err: err}
+			}
+			continue
+		}
+
+		t, err := parseRecord(record, columns)
+		if err == nil {
+			err = t.Validate()
+		}
+		if err != nil {
+			return nil, &Error{Line: line, Err: err}
+		}
+
+		slots = append(slots, t)
+	}
+
+	return newTimeline(slots, true)
+}
+
+// ReadJSON reads a timeline from a JSON array of slots
+func ReadJSON(r io.Reader) (*Timeline, error) {
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+
+	slots := make([]Timecode, 0)
+	err := decoder.Decode(&slots)
+	if err != nil {
+		return nil, err
+	}
+
+	return newTimeline(slots, false)
+}
+
+// ReadYAML reads a timeline from a YAML list of slots
+func ReadYAML(r io.Reader) (*Timeline, error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	slots := make([]Timecode, 0)
+	err = yaml.UnmarshalStrict(b, &slots)
+	if err != nil {
+		return nil, err
+	}
+
+	return newTimeline(slots, false)
+}
+
+// newTimeline checks the slots make a valid timeline. CSV slots have already been validated with their line numbers
+func newTimeline(slots []Timecode, validated bool) (*Timeline, error) {
+	if len(slots) == 0 {
+		return nil, errors.New("There are no timecodes")
+	}
+
+	names := make(map[string]bool)
+	for i := range slots {
+		if !validated {
+			err := slots[i].Validate()
+			if err != nil {
+				return nil, &Error{Slot: i, Err: err}
+			}
+		}
+
+		name := slots[i].Name
+		if name == "" {
+			continue
+		}
+
+		if names[name] {
+			return nil, &Error{Slot: i, Err: fmt.Errorf("Slot %s is named more than once", name)}
+		}
+		names[name] = true
+	}
+
+	return &Timeline{Slots: slots}, nil
+}
+
+// Validate checks the slot makes sense, filling in Length from MaxLength if it isn't set
+func (t *Timecode) Validate() error {
+	if t.Length == 0 {
+		t.Length = t.MaxLength
+	}
+
+	if t.Length <= 0 {
+		return errors.New("length must be greater than 0")
+	}
+
+	if t.MinLength < 0 || t.MinLength > t.Length {
+		return fmt.Errorf("min_length must be between 0 and the length, %g", t.Length)
+	}
+
+	if t.MaxLength != 0 && t.MaxLength < t.Length {
+		return fmt.Errorf("max_length must be at least the length, %g", t.Length)
+	}
+
+	if t.Anchor != nil && (*t.Anchor < 0 || *t.Anchor > 1) {
+		return errors.New("anchor must be between 0 and 1")
+	}
+
+	if t.Crop != "" && t.Aspect != "" {
+		return errors.New("crop and aspect can't both be set")
+	}
+
+	if t.Crop != "" && !cropPattern.MatchString(t.Crop) {
+		return fmt.Errorf("crop %q must be width:height or width:height:x:y", t.Crop)
+	}
+
+	if t.Aspect != "" {
+		m := aspectPattern.FindStringSubmatch(t.Aspect)
+		if m == nil || m[1] == "0" || m[2] == "0" {
+			return fmt.Errorf("aspect %q must be width:height", t.Aspect)
+		}
+	}
+
+	return nil
+}
+
+// Filter gets the ffmpeg video filter for the slot's crop or aspect, or "" if it has neither
+func (t Timecode) Filter() string {
+	if t.Crop != "" {
+		return "crop=" + t.Crop
+	}
+
+	m := aspectPattern.FindStringSubmatch(t.Aspect)
+	if m == nil {
+		return ""
+	}
+
+	// Take the largest centred area of the frame with the right aspect ratio
+	return fmt.Sprintf("crop='min(iw,ih*%[1]s/%[2]s)':'min(ih,iw*%[2]s/%[1]s)'", m[1], m[2])
+}
+
+// isHeader reports whether a row is a header rather than a slot, which is when it doesn't start with a number
+func isHeader(record []string) bool {
+	_, err := strconv.ParseFloat(strings.TrimSpace(record[0]), 64)
+	return err != nil
+}
+
+// header gets the columns a header row names
+func header(record []string) ([]string, error) {
+	seen := make(map[string]bool)
+	columns := make([]string, len(record))
+
+	for i, c := range record {
+		c = strings.ToLower(strings.TrimSpace(c))
+		if !known(c) {
+			return nil, fmt.Errorf("Unknown column %q", c)
+		}
+
+		if seen[c] {
+			return nil, fmt.Errorf("Column %q appears more than once", c)
+		}
+
+		seen[c] = true
+		columns[i] = c
+	}
+
+	if !seen["length"] && !seen["max_length"] {
+		return nil, errors.New("There must be a length or max_length column")
+	}
+
+	return columns, nil
+}
+
+func known(column string) bool {
+	for _, c := range Columns {
+		if c == column {
+			return true
+		}
+	}
+
+	return false
+}
+
+// parseRecord reads a slot from a row of columns
+func parseRecord(record []string, columns []string) (Timecode, error) {
+	t := Timecode{}
+
+	if len(record) > len(columns) {
+		return t, fmt.Errorf("There are %d columns, expected at most %d", len(record), len(columns))
+	}
+
+	for i, value := range record {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+
+		var err error
+		switch columns[i] {
+		case "length":
+			t.Length, err = strconv.ParseFloat(value, 64)
+		case "min_length":
+			t.MinLength, err = strconv.ParseFloat(value, 64)
+		case "max_length":
+			t.MaxLength, err = strconv.ParseFloat(value, 64)
+		case "anchor":
+			var anchor float64
+			anchor, err = strconv.ParseFloat(value, 64)
+			t.Anchor = &anchor
+		case "name":
+			t.Name = value
+		case "crop":
+			t.Crop = value
+		case "aspect":
+			t.Aspect = value
+		}
+
+		if err != nil {
+			return t, fmt.Errorf("%s %q is not a number", columns[i], value)
 		}
-		len, err := strconv.ParseFloat(record[0], 64)
-		timecodes = append(timecodes, Timecode{
-			Length: len,
-		})
-		row++
 	}
 
-	return &timecodes, nil
+	return t, nil
 }
@@ -0,0 +1,140 @@
+package timecode
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadCSVLengths(t *testing.T) {
+	timeline, err := ReadCSV(strings.NewReader("5.8\n11.96\n6.04\n"))
+
+	assert.Nil(t, err)
+	assert.Equal(t, 3, timeline.Len())
+	assert.Equal(t, 11.96, timeline.Slots[1].Length)
+}
+
+func TestReadCSVHeader(t *testing.T) {
+	input := "name,max_length,min_length,anchor,aspect\n" +
+		"# The opening shot\n" +
+		"intro,8,4,0.1,9:16\n" +
+		"outro,5,,,\n"
+
+	timeline, err := ReadCSV(strings.NewReader(input))
+
+	assert.Nil(t, err)
+	assert.Equal(t, 2, timeline.Len())
+
+	intro := timeline.Slots[0]
+	assert.Equal(t, "intro", intro.Name)
+	assert.Equal(t, 8.0, intro.Length)
+	assert.Equal(t, 4.0, intro.MinLength)
+	assert.Equal(t, 0.1, *intro.Anchor)
+	assert.Equal(t, "crop='min(iw,ih*9/16)':'min(ih,iw*16/9)'", intro.Filter())
+
+	assert.Nil(t, timeline.Slots[1].Anchor)
+	assert.Equal(t, "", timeline.Slots[1].Filter())
+}
+
+func TestReadCSVErrors(t *testing.T) {
+	testcases := map[string]string{
+		"5.8\nabc\n":                      "line 2: length \"abc\" is not a number",
+		"5.8\n\n-1\n":                     "line 3: length must be greater than 0",
+		"length,anchor\n3,2\n":            "line 2: anchor must be between 0 and 1",
+		"length,colour\n3,red\n":          "line 1: Unknown column \"colour\"",
+		"name\nintro\n":                   "line 1: There must be a length or max_length column",
+		"length,crop\n3,big\n":            "line 2: crop \"big\" must be width:height or width:height:x:y",
+		"3,a,,,,,,extra\n":                "line 1: There are 8 columns, expected at most 7",
+		"length,min_length\n3,4\n":        "line 2: min_length must be between 0 and the length, 3",
+		"length,name\n3,intro\n4,intro\n": "slot 1: Slot intro is named more than once",
+	}
+
+	for input, expected := range testcases {
+		_, err := ReadCSV(strings.NewReader(input))
+
+		assert.NotNil(t, err, input)
+		if err != nil {
+			assert.Equal(t, expected, err.Error(), input)
+		}
+	}
+}
+
+func TestReadJSONAndYAML(t *testing.T) {
+	timeline, err := ReadJSON(strings.NewReader(`[{"name": "intro", "length": 4, "crop": "640:360"}, {"max_length": 6}]`))
+	assert.Nil(t, err)
+	assert.Equal(t, "crop=640:360", timeline.Slots[0].Filter())
+	assert.Equal(t, 6.0, timeline.Slots[1].Length)
+
+	timeline, err = ReadYAML(strings.NewReader("- name: intro\n  length: 4\n  anchor: 0.5\n- length: 2\n"))
+	assert.Nil(t, err)
+	assert.Equal(t, 0.5, *timeline.Slots[0].Anchor)
+
+	_, err = ReadYAML(strings.NewReader("- length: 4\n  colour: red\n"))
+	assert.NotNil(t, err)
+
+	_, err = ReadJSON(strings.NewReader(`[{"length": 4}, {"length": 0}]`))
+	var e *Error
+	assert.True(t, errors.As(err, &e))
+	assert.Equal(t, 1, e.Slot)
+}
+
+func TestLoadDir(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "default.csv"), []byte("5.8\n11.96\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "waltz.yml"), []byte("- length: 3\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "README.md"), []byte("Not a timeline"), 0644)
+
+	timelines, err := LoadDir(dir, DefaultName)
+
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"default", "waltz"}, timelines.Names())
+
+	timeline, ok := timelines.Get("")
+	assert.True(t, ok)
+	assert.Equal(t, "default", timeline.Name)
+	assert.Equal(t, 2, timeline.Len())
+
+	_, ok = timelines.Get("tango")
+	assert.False(t, ok)
+
+	_, err = LoadDir(dir, "tango")
+	assert.NotNil(t, err)
+}
+
+func TestStoreWatch(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "default.csv")
+	os.WriteFile(path, []byte("5.8\n"), 0644)
+
+	load := func() (*Timelines, error) {
+		return LoadDir(dir, DefaultName)
+	}
+
+	timelines, err := load()
+	assert.Nil(t, err)
+
+	store := NewStore(timelines)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go store.Watch(ctx, dir, load)
+	time.Sleep(100 * time.Millisecond)
+
+	// A broken file is ignored
+	os.WriteFile(path, []byte("abc\n"), 0644)
+	time.Sleep(ReloadDelay + 500*time.Millisecond)
+	assert.Equal(t, timelines.Version, store.Load().Version)
+
+	os.WriteFile(path, []byte("5.8\n3.2\n"), 0644)
+	assert.Eventually(t, func() bool {
+		timeline, _ := store.Load().Get("")
+		return timeline.Len() == 2
+	}, 5*time.Second, 100*time.Millisecond)
+
+	assert.NotEqual(t, timelines.Version, store.Load().Version)
+}
@@ -0,0 +1,116 @@
+package timecode
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+)
+
+// DefaultName is the timeline videos are split against when they don't ask for one
+const DefaultName = "default"
+
+var namePattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// Timelines are the named timelines videos can be split against, one of which is the default.
+// Version changes whenever any of them does
+type Timelines struct {
+	Default string
+	Version string
+	byName  map[string]*Timeline
+}
+
+// Single makes a set of just one timeline, which is the default
+func Single(t *Timeline) *Timelines {
+	timelines := &Timelines{
+		Default: t.Name,
+		byName:  map[string]*Timeline{t.Name: t},
+	}
+	timelines.Version = timelines.version()
+
+	return timelines
+}
+
+// LoadDir reads every timeline file in dir, named after the file without its extension. def must be one of them
+func LoadDir(dir, def string) (*Timelines, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &Timelines{
+		Default: def,
+		byName:  make(map[string]*Timeline),
+	}
+
+	for _, e := range entries {
+		path := filepath.Join(dir, e.Name())
+		if e.IsDir() || !isFormat(formatOf(path)) {
+			continue
+		}
+
+		timeline, err := LoadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		if !namePattern.MatchString(timeline.Name) {
+			return nil, fmt.Errorf("Timeline %s must be named with only letters, numbers, _ and -", path)
+		}
+
+		if _, ok := t.byName[timeline.Name]; ok {
+			return nil, fmt.Errorf("There is more than one timeline named %s in %s", timeline.Name, dir)
+		}
+
+		t.byName[timeline.Name] = timeline
+	}
+
+	if len(t.byName) == 0 {
+		return nil, fmt.Errorf("There are no timelines in %s", dir)
+	}
+
+	if _, ok := t.byName[def]; !ok {
+		return nil, fmt.Errorf("The default timeline %s isn't in %s", def, dir)
+	}
+	t.Version = t.version()
+
+	return t, nil
+}
+
+// Get gets the timeline with a name, or the default if name is empty
+func (t *Timelines) Get(name string) (*Timeline, bool) {
+	if name == "" {
+		name = t.Default
+	}
+
+	timeline, ok := t.byName[name]
+	return timeline, ok
+}
+
+// Names gets the names of the timelines, in order
+func (t *Timelines) Names() []string {
+	names := make([]string, 0, len(t.byName))
+	for name := range t.byName {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// version is a hash of the name and version of every timeline
+func (t *Timelines) version() string {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "%s\n", t.Default)
+	for _, name := range t.Names() {
+		fmt.Fprintf(&b, "%s:%s\n", name, t.byName[name].Version)
+	}
+
+	return version(b.Bytes())
+}
+
+func isFormat(format string) bool {
+	return format == FormatCSV || format == FormatJSON || format == FormatYAML
+}
@@ -0,0 +1,42 @@
+package tracing
+
+import (
+	"context"
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"testing"
+)
+
+func TestPropagateThroughHeaders(t *testing.T) {
+	_, err := Setup(context.Background(), ExporterNone, "")
+	assert.Nil(t, err)
+
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+
+	ctx, span := Start(context.Background(), "publish")
+	headers := amqp.Table{}
+	Inject(ctx, headers)
+	span.End()
+
+	assert.NotEmpty(t, headers["traceparent"])
+
+	ctx = Extract(context.Background(), headers)
+	assert.Equal(t, TraceID(ctx), span.SpanContext().TraceID().String())
+
+	_, child := Start(ctx, "process upload")
+	End(child, nil)
+
+	spans := recorder.Ended()
+	assert.Len(t, spans, 2)
+	assert.Equal(t, spans[0].SpanContext().TraceID(), spans[1].SpanContext().TraceID())
+	assert.Equal(t, spans[0].SpanContext().SpanID(), spans[1].Parent().SpanID())
+}
+
+func TestSetupUnknownExporter(t *testing.T) {
+	_, err := Setup(context.Background(), "zipkin", "")
+	assert.NotNil(t, err)
+}
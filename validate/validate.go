@@ -0,0 +1,115 @@
+package validate
+
+import (
+	"fmt"
+	"github.com/therealpenguin/takeabow-upload-processor/probe"
+	"strings"
+)
+
+const StatusTooShort = "rejected_too_short"
+const StatusTooLong = "rejected_too_long"
+const StatusNoVideo = "rejected_no_video"
+const StatusResolution = "rejected_resolution"
+const StatusFileSize = "rejected_file_size"
+const StatusContainer = "rejected_container"
+const StatusCodec = "rejected_codec"
+
+// Rules is what an upload has to satisfy before we transcode it. Zero values mean no limit
+type Rules struct {
+	MinDuration  float64
+	MaxDuration  float64
+	RequireVideo bool
+	MaxWidth     int
+	MaxHeight    int
+	MaxFileSize  int64
+	Containers   []string
+	VideoCodecs  []string
+	AudioCodecs  []string
+}
+
+// Violation is a rule an upload broke. Status is what the video's row should be set to
+type Violation struct {
+	Status string
+	Reason string
+}
+
+func (v *Violation) Error() string {
+	return v.Reason
+}
+
+// CheckSize checks a file isn't too big. It's checked before downloading when the size is known, as well as after
+func (r Rules) CheckSize(size int64) error {
+	if r.MaxFileSize > 0 && size > r.MaxFileSize {
+		return &Violation{StatusFileSize, fmt.Sprintf("File is %d bytes, the most is %d", size, r.MaxFileSize)}
+	}
+
+	return nil
+}
+
+// Check gets the first rule that info and size break, or nil if they break none
+func (r Rules) Check(info *probe.MediaInfo, size int64) error {
+	if err := r.CheckSize(size); err != nil {
+		return err
+	}
+
+	if len(r.Containers) > 0 && !anyAllowed(strings.Split(info.Container, ","), r.Containers) {
+		return &Violation{StatusContainer, fmt.Sprintf("Container %s is not allowed", info.Container)}
+	}
+
+	if r.MinDuration > 0 && info.Duration < r.MinDuration {
+		return &Violation{StatusTooShort, fmt.Sprintf("Video is %.2fs long, the least is %.2fs", info.Duration, r.MinDuration)}
+	}
+
+	if r.MaxDuration > 0 && info.Duration > r.MaxDuration {
+		return &Violation{StatusTooLong, fmt.Sprintf("Video is %.2fs long, the most is %.2fs", info.Duration, r.MaxDuration)}
+	}
+
+	v := info.Video()
+	if v == nil {
+		if r.RequireVideo {
+			return &Violation{StatusNoVideo, "File has no video stream"}
+		}
+	} else {
+		if !r.fits(v.Width, v.Height) {
+			return &Violation{StatusResolution, fmt.Sprintf("Video is %dx%d, the most is %dx%d", v.Width, v.Height, r.MaxWidth, r.MaxHeight)}
+		}
+	}
+
+	// Video and audio streams each have their own list, so allowing some video codecs doesn't rule out every audio one
+	for _, s := range info.Streams {
+		allowed := r.VideoCodecs
+		if s.Type == probe.StreamAudio {
+			allowed = r.AudioCodecs
+		} else if s.Type != probe.StreamVideo {
+			continue
+		}
+
+		if len(allowed) > 0 && !anyAllowed([]string{s.Codec}, allowed) {
+			return &Violation{StatusCodec, fmt.Sprintf("%s codec %s is not allowed", s.Type, s.Codec)}
+		}
+	}
+
+	return nil
+}
+
+// fits reports whether a video is within the maximum resolution either way up, so portrait videos get the same limit as landscape ones
+func (r Rules) fits(width, height int) bool {
+	within := func(w, h int) bool {
+		return (r.MaxWidth == 0 || w <= r.MaxWidth) && (r.MaxHeight == 0 || h <= r.MaxHeight)
+	}
+
+	return within(width, height) || within(height, width)
+}
+
+// anyAllowed reports whether any of names is in allowed
+func anyAllowed(names, allowed []string) bool {
+	for _, n := range names {
+		for _, a := range allowed {
+			if strings.EqualFold(strings.TrimSpace(n), a) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
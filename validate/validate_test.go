@@ -0,0 +1,109 @@
+package validate
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/therealpenguin/takeabow-upload-processor/probe"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	rules := Rules{
+		MinDuration:  3,
+		MaxDuration:  600,
+		RequireVideo: true,
+		MaxWidth:     3840,
+		MaxHeight:    2160,
+		MaxFileSize:  1 << 30,
+		Containers:   []string{"mp4", "webm"},
+		VideoCodecs:  []string{"h264", "vp9"},
+		AudioCodecs:  []string{"aac", "opus"},
+	}
+
+	video := func(width, height int) probe.Stream {
+		return probe.Stream{Type: probe.StreamVideo, Codec: "h264", Width: width, Height: height}
+	}
+	audio := probe.Stream{Type: probe.StreamAudio, Codec: "aac"}
+
+	type TestCase struct {
+		Info   probe.MediaInfo
+		Size   int64
+		Status string
+	}
+
+	testcases := []TestCase{
+		{
+			Info: probe.MediaInfo{Container: "mov,mp4,m4a", Duration: 30, Streams: []probe.Stream{video(1920, 1080), audio}},
+		},
+		{
+			// Portrait 4K gets the same limit as landscape
+			Info: probe.MediaInfo{Container: "mp4", Duration: 30, Streams: []probe.Stream{video(2160, 3840)}},
+		},
+		{
+			Info:   probe.MediaInfo{Container: "mp4", Duration: 30, Streams: []probe.Stream{video(7680, 4320)}},
+			Status: StatusResolution,
+		},
+		{
+			Info:   probe.MediaInfo{Container: "mp4", Duration: 1, Streams: []probe.Stream{video(1920, 1080)}},
+			Status: StatusTooShort,
+		},
+		{
+			Info:   probe.MediaInfo{Container: "mp4", Duration: 3 * 60 * 60, Streams: []probe.Stream{video(1920, 1080)}},
+			Status: StatusTooLong,
+		},
+		{
+			Info:   probe.MediaInfo{Container: "mp4", Duration: 30, Streams: []probe.Stream{audio}},
+			Status: StatusNoVideo,
+		},
+		{
+			Info:   probe.MediaInfo{Container: "avi", Duration: 30, Streams: []probe.Stream{video(1920, 1080)}},
+			Status: StatusContainer,
+		},
+		{
+			Info:   probe.MediaInfo{Container: "mp4", Duration: 30, Streams: []probe.Stream{{Type: probe.StreamVideo, Codec: "prores", Width: 1920, Height: 1080}}},
+			Status: StatusCodec,
+		},
+		{
+			Info:   probe.MediaInfo{Container: "mp4", Duration: 30, Streams: []probe.Stream{video(1920, 1080)}},
+			Size:   2 << 30,
+			Status: StatusFileSize,
+		},
+	}
+
+	for i, tc := range testcases {
+		err := rules.Check(&tc.Info, tc.Size)
+		if tc.Status == "" {
+			assert.Nil(t, err, "case %d", i)
+			continue
+		}
+
+		v, ok := err.(*Violation)
+		assert.True(t, ok, "case %d", i)
+		if ok {
+			assert.Equal(t, tc.Status, v.Status, "case %d", i)
+		}
+	}
+}
+
+func TestNoRules(t *testing.T) {
+	info := probe.MediaInfo{Container: "mp4", Duration: 30}
+	assert.Nil(t, Rules{}.Check(&info, 1<<40))
+}
+
+func TestCodecsByStreamType(t *testing.T) {
+	info := probe.MediaInfo{Container: "mp4", Duration: 30, Streams: []probe.Stream{
+		{Type: probe.StreamVideo, Codec: "h264", Width: 1920, Height: 1080},
+		{Type: probe.StreamAudio, Codec: "mp3"},
+	}}
+
+	// Only listing video codecs leaves audio alone
+	assert.Nil(t, Rules{VideoCodecs: []string{"h264"}}.Check(&info, 0))
+
+	err := Rules{VideoCodecs: []string{"h264"}, AudioCodecs: []string{"aac"}}.Check(&info, 0)
+	assert.Equal(t, StatusCodec, err.(*Violation).Status)
+}
+
+func TestCheckSize(t *testing.T) {
+	assert.Nil(t, Rules{}.CheckSize(1<<40))
+	assert.Nil(t, Rules{MaxFileSize: 100}.CheckSize(100))
+	assert.Equal(t, StatusFileSize, Rules{MaxFileSize: 100}.CheckSize(101).(*Violation).Status)
+}
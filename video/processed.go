@@ -0,0 +1,81 @@
+package video
+
+import (
+	"context"
+	"github.com/therealpenguin/takeabow-upload-processor/storage"
+)
+
+// ProcessedVideo is a video being processed again from the rendition we already made of it, so it doesn't have to be
+// downloaded from where it came from. If the rendition is gone, the video is got from the original source instead
+type ProcessedVideo struct {
+	*VideoRequest
+	storage     storage.Backend
+	original    Video
+	useOriginal bool
+}
+
+// NewProcessedVideo gets a video from r.ProcessedKey in storage, falling back to original, which may be nil
+func NewProcessedVideo(r *VideoRequest, storage storage.Backend, original Video) *ProcessedVideo {
+	return &ProcessedVideo{
+		VideoRequest: r,
+		storage:      storage,
+		original:     original,
+	}
+}
+
+// HasVideo checks the rendition exists, or else that the original does
+func (v *ProcessedVideo) HasVideo(ctx context.Context) (bool, error) {
+	_, err := v.storage.Head(ctx, storage.CleanKey(v.ProcessedKey))
+	if err == nil {
+		return true, nil
+	}
+
+	if err != storage.ErrNotExist {
+		return false, err
+	}
+
+	v.useOriginal = true
+	if v.original == nil {
+		return false, nil
+	}
+
+	return v.original.HasVideo(ctx)
+}
+
+func (v *ProcessedVideo) GetVideo(ctx context.Context, dir string) (string, error) {
+	if v.useOriginal {
+		return v.original.GetVideo(ctx, dir)
+	}
+
+	return download(ctx, v.storage, storage.CleanKey(v.ProcessedKey), destination(dir, v.Id, ""))
+}
+
+// Size gets the size of the rendition, or of the original if that's being used instead
+func (v *ProcessedVideo) Size(ctx context.Context) (int64, error) {
+	if v.useOriginal {
+		if s, ok := v.original.(Sizer); ok {
+			return s.Size(ctx)
+		}
+		return 0, nil
+	}
+
+	o, err := v.storage.Head(ctx, storage.CleanKey(v.ProcessedKey))
+	if err != nil {
+		return 0, err
+	}
+
+	return o.Size, nil
+}
+
+// Rendition gets the key of the rendition the video is got from, or "" if it's got from the original instead
+func (v *ProcessedVideo) Rendition() string {
+	if v.useOriginal {
+		return ""
+	}
+
+	return v.ProcessedKey
+}
+
+func (v *ProcessedVideo) GetRequest() *VideoRequest {
+	return v.VideoRequest
+}
@@ -20,6 +20,39 @@ type VideoRequest struct {
 	Url      string `json:"url"`
 	Status   string `json:"string"`
 	Duration int    `json:"duration"`
+	Profile  string `json:"profile,omitempty"`
+	Timeline string `json:"timeline,omitempty"`
+
+	// ProcessedKey is the rendition of a video being reprocessed, which is used instead of the original unless Force is set.
+	// They're set by the processor for a reprocess, never by whoever published the request
+	ProcessedKey string `json:"-"`
+	Force        bool   `json:"-"`
+
+	// CallbackURL is POSTed the outcome once the video is done with
+	CallbackURL string `json:"callback_url,omitempty"`
+
+	// Renditions are the keys each profile was uploaded to, by profile name
+	Renditions map[string]string `json:"-"`
+	Splits     []Split           `json:"-"`
+
+	// Poster is the key of the video's best frame, and Thumbnails the keys of stills spread through it
+	Poster     string   `json:"-"`
+	Thumbnails []string `json:"-"`
+
+	// TimelineVersion is the version of the timeline the video was split against
+	TimelineVersion string `json:"-"`
+
+	// ProfileVersion is the version of the primary profile the video's primary rendition was rendered with
+	ProfileVersion string `json:"-"`
+}
+
+// Split is a slot cut from the video, and the part of the source it was cut from
+type Split struct {
+	Timeline string  `json:"timeline"`
+	Slot     int     `json:"slot"`
+	Key      string  `json:"key"`
+	Start    float64 `json:"start"`
+	End      float64 `json:"end"`
 }
 
 // NewVideoRequest creates a VideoRequest object from a byte array. It attempts to get the source of the video
@@ -75,6 +108,14 @@ func (v *VideoRequest) SetStatus(status string, db *sql.DB) error {
 	return err
 }
 
+// GetStatus gets the status the video's row has now
+func (v *VideoRequest) GetStatus(db *sql.DB) (string, error) {
+	var status sql.NullString
+	err := db.QueryRow(`SELECT status FROM videos WHERE id = ?`, v.Id).Scan(&status)
+
+	return status.String, err
+}
+
 func (v *VideoRequest) SetOriginalUrl(db *sql.DB) error {
 	query := `UPDATE videos SET original_url = ? WHERE id = ?`
 	_, err := db.Exec(query, v.Url, v.Id)
@@ -88,3 +129,45 @@ func (v *VideoRequest) SaveDuration(db *sql.DB) error {
 
 	return err
 }
+
+// SaveProfileVersion records which version of the primary profile the video's primary rendition was rendered with
+func (v *VideoRequest) SaveProfileVersion(db *sql.DB) error {
+	query := `UPDATE videos SET profile_version = ? WHERE id = ?`
+	_, err := db.Exec(query, v.ProfileVersion, v.Id)
+
+	return err
+}
+
+// SaveTimeline records which version of which timeline the video was split against
+func (v *VideoRequest) SaveTimeline(db *sql.DB) error {
+	query := `UPDATE videos SET timeline = ?, timeline_version = ? WHERE id = ?`
+	_, err := db.Exec(query, v.Timeline, v.TimelineVersion, v.Id)
+
+	return err
+}
+
+// SaveThumbnails records the keys of the video's poster frame and stills
+func (v *VideoRequest) SaveThumbnails(db *sql.DB) error {
+	thumbnails, err := json.Marshal(v.Thumbnails)
+	if err != nil {
+		return err
+	}
+
+	query := `UPDATE videos SET poster_key = ?, thumbnail_keys = ? WHERE id = ?`
+	_, err = db.Exec(query, v.Poster, string(thumbnails), v.Id)
+
+	return err
+}
+
+// SaveSplits records which part of the video each slot was cut from
+func (v *VideoRequest) SaveSplits(db *sql.DB) error {
+	query := `REPLACE INTO video_splits (video_id, timeline, slot, split_key, start_seconds, end_seconds) VALUES (?, ?, ?, ?, ?, ?)`
+	for _, s := range v.Splits {
+		_, err := db.Exec(query, v.Id, s.Timeline, s.Slot, s.Key, s.Start, s.End)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -1,41 +1,36 @@
 package video
 
 import (
+	"context"
 	"fmt"
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/s3"
-	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/therealpenguin/takeabow-upload-processor/storage"
+	"io"
 	"net/url"
 	"os"
 )
 
-// S3Video denotes a VideoRequest that you can perform S3 specific things on
+// S3Video denotes a VideoRequest that was uploaded to our storage
 type S3Video struct {
 	*VideoRequest
-	s3     *s3.S3
-	sess   *session.Session
-	bucket string
+	storage storage.Backend
 }
 
-func NewS3Video(r *VideoRequest, s3 *s3.S3, sess *session.Session, bucket string) *S3Video {
-	return &S3Video{r, s3, sess, bucket}
+func NewS3Video(r *VideoRequest, storage storage.Backend) *S3Video {
+	return &S3Video{r, storage}
 }
 
-// HasVideo checks to see if the S3 key exists
-func (v *S3Video) HasVideo() (bool, error) {
-	url, err := url.Parse(v.GetRequest().Url)
-
+// HasVideo checks to see if the key exists
+func (v *S3Video) HasVideo(ctx context.Context) (bool, error) {
+	key, err := v.key()
 	if err != nil {
 		return false, err
 	}
 
-	params := s3.HeadObjectInput{
-		Bucket: aws.String(v.bucket),
-		Key:    aws.String(url.Path),
-	}
+	_, err = v.storage.Head(ctx, key)
 
-	_, err = v.s3.HeadObject(&params)
+	if err == storage.ErrNotExist {
+		return false, nil
+	}
 
 	if err != nil {
 		return false, err
@@ -44,37 +39,65 @@ func (v *S3Video) HasVideo() (bool, error) {
 	return true, err
 }
 
-func (v *S3Video) GetVideo(dir string) (string, error) {
-	dest := dir + "/" + v.Id
-	url, err := url.Parse(v.GetRequest().Url)
-
+func (v *S3Video) GetVideo(ctx context.Context, dir string) (string, error) {
+	key, err := v.key()
 	if err != nil {
 		return "", err
 	}
 
-	// Create a downloader with the session and default options
-	downloader := s3manager.NewDownloader(v.sess)
+	return download(ctx, v.storage, key, destination(dir, v.Id, ""))
+}
 
-	// Create a file to write the S3 Object contents to.
-	f, err := os.Create(dest)
-	defer f.Close()
+// Size gets the size of the object
+func (v *S3Video) Size(ctx context.Context) (int64, error) {
+	key, err := v.key()
+	if err != nil {
+		return 0, err
+	}
 
+	o, err := v.storage.Head(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+
+	return o.Size, nil
+}
+
+func (v *S3Video) GetRequest() *VideoRequest {
+	return v.VideoRequest
+}
+
+// download copies the object at key in store to a file at dest
+func download(ctx context.Context, store storage.Backend, key, dest string) (string, error) {
+	r, err := store.Get(ctx, key)
+	if err != nil {
+		return "", fmt.Errorf("failed to download %q, %v", key, err)
+	}
+	defer r.Close()
+
+	// Create a file to write the object's contents to.
+	f, err := os.Create(dest)
 	if err != nil {
 		return "", fmt.Errorf("failed to create file %q, %v", dest, err)
 	}
+	defer f.Close()
 
-	// Write the contents of S3 Object to the file
-	_, err = downloader.Download(f, &s3.GetObjectInput{
-		Bucket: aws.String(v.bucket),
-		Key:    aws.String(url.Path),
-	})
+	_, err = io.Copy(f, r)
 	if err != nil {
-		return "", fmt.Errorf("failed to upload file, %v", err)
+		removePartial(dest)
+		return "", fmt.Errorf("failed to download file, %v", err)
 	}
 
 	return dest, nil
 }
 
-func (v *S3Video) GetRequest() *VideoRequest {
-	return v.VideoRequest
+// key gets the storage key from the path of the video's URL
+func (v *S3Video) key() (string, error) {
+	url, err := url.Parse(v.GetRequest().Url)
+
+	if err != nil {
+		return "", err
+	}
+
+	return storage.CleanKey(url.Path), nil
 }
@@ -0,0 +1,88 @@
+package video
+
+import (
+	"bytes"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/therealpenguin/takeabow-upload-processor/storage"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestS3VideoFromStorage(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemory()
+	r := &VideoRequest{
+		Id:  "foo",
+		Url: "https://takeabow.s3.amazonaws.com/upload/foo.mp4",
+	}
+	v := NewS3Video(r, store)
+
+	has, err := v.HasVideo(ctx)
+	assert.Nil(t, err)
+	assert.False(t, has)
+
+	err = store.Put(ctx, "upload/foo.mp4", bytes.NewBufferString("video"))
+	assert.Nil(t, err)
+
+	has, err = v.HasVideo(ctx)
+	assert.Nil(t, err)
+	assert.True(t, has)
+
+	// The size is known before downloading
+	size, err := v.Size(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(5), size)
+
+	dir, err := ioutil.TempDir("", "video")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	location, err := v.GetVideo(ctx, dir)
+	assert.Nil(t, err)
+
+	data, err := ioutil.ReadFile(location)
+	assert.Nil(t, err)
+	assert.Equal(t, "video", string(data))
+}
+
+func TestProcessedVideoFallsBackToOriginal(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemory()
+	store.Put(ctx, "upload/foo.mp4", bytes.NewBufferString("original"))
+
+	r := &VideoRequest{Id: "foo", Url: "https://takeabow.s3.amazonaws.com/upload/foo.mp4", ProcessedKey: "processed/foo.mp4"}
+	v, err := FromRequest(r, store)
+	assert.Nil(t, err)
+
+	dir, err := ioutil.TempDir("", "video")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	// Without the rendition, the original is used
+	has, err := v.HasVideo(ctx)
+	assert.Nil(t, err)
+	assert.True(t, has)
+
+	location, err := v.GetVideo(ctx, dir)
+	assert.Nil(t, err)
+	data, _ := ioutil.ReadFile(location)
+	assert.Equal(t, "original", string(data))
+	assert.Equal(t, "", v.(*ProcessedVideo).Rendition())
+
+	// With it, the rendition is
+	store.Put(ctx, "processed/foo.mp4", bytes.NewBufferString("processed"))
+	r = &VideoRequest{Id: "foo", Url: "https://www.youtube.com/watch?v=foo", ProcessedKey: "processed/foo.mp4"}
+	v, _ = FromRequest(r, store)
+
+	has, err = v.HasVideo(ctx)
+	assert.Nil(t, err)
+	assert.True(t, has)
+
+	location, err = v.GetVideo(ctx, dir)
+	assert.Nil(t, err)
+	data, _ = ioutil.ReadFile(location)
+	assert.Equal(t, "processed", string(data))
+	assert.Equal(t, "processed/foo.mp4", v.(*ProcessedVideo).Rendition())
+}
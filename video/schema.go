@@ -0,0 +1,69 @@
+package video
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// Tables are the tables the processor keeps itself. They're created at startup if they're missing
+var Tables = []string{
+	`CREATE TABLE IF NOT EXISTS video_splits (
+		video_id VARCHAR(64) NOT NULL,
+		timeline VARCHAR(255) NOT NULL,
+		slot INT NOT NULL,
+		split_key VARCHAR(1024) NOT NULL,
+		start_seconds DOUBLE NOT NULL,
+		end_seconds DOUBLE NOT NULL,
+		PRIMARY KEY (video_id, timeline, slot)
+	)`,
+}
+
+// Column is a column the processor writes to in a table it doesn't own
+type Column struct {
+	Table      string
+	Name       string
+	Definition string
+}
+
+// Columns are added to their tables at startup if they're missing
+var Columns = []Column{
+	{"videos", "timeline", "VARCHAR(255) NULL"},
+	{"videos", "timeline_version", "VARCHAR(64) NULL"},
+	{"videos", "poster_key", "VARCHAR(1024) NULL"},
+	{"videos", "thumbnail_keys", "TEXT NULL"},
+	{"videos", "profile_version", "VARCHAR(64) NULL"},
+}
+
+// Migrate brings the schema up to date with what the processor writes
+func Migrate(ctx context.Context, db *sql.DB) error {
+	for _, table := range Tables {
+		_, err := db.ExecContext(ctx, table)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, c := range Columns {
+		err := addColumn(ctx, db, c)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// addColumn adds a column unless its table already has it. MySQL can't ADD COLUMN IF NOT EXISTS, so it asks first
+func addColumn(ctx context.Context, db *sql.DB, c Column) error {
+	var n int
+	query := `SELECT COUNT(*) FROM information_schema.columns WHERE table_schema = DATABASE() AND table_name = ? AND column_name = ?`
+	err := db.QueryRowContext(ctx, query, c.Table, c.Name).Scan(&n)
+	if err != nil || n > 0 {
+		return err
+	}
+
+	_, err = db.ExecContext(ctx, fmt.Sprintf("ALTER TABLE `%s` ADD COLUMN `%s` %s", c.Table, c.Name, c.Definition))
+
+	return err
+}
@@ -1,44 +1,73 @@
 package video
 
 import (
+	"context"
 	"errors"
 	"fmt"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/s3"
-	"log"
-	"os/exec"
+	"github.com/therealpenguin/takeabow-upload-processor/storage"
+	"os"
+	"path/filepath"
 )
 
 // Video is an interface that allows different sources of videos to say how to get a video file
 type Video interface {
-	HasVideo() (bool, error)
-	GetVideo(dir string) (string, error)
+	HasVideo(ctx context.Context) (bool, error)
+	GetVideo(ctx context.Context, dir string) (string, error)
 	GetRequest() *VideoRequest
 }
 
-func New(b []byte, s3 *s3.S3, sess *session.Session, bucket string) (Video, error) {
+// Sizer is implemented by videos that can tell how many bytes they are before they're downloaded.
+// A size of 0 means it isn't known
+type Sizer interface {
+	Size(ctx context.Context) (int64, error)
+}
+
+func New(b []byte, store storage.Backend) (Video, error) {
 	r, err := NewVideoRequest(b)
 	if err != nil {
 		return nil, err
 	}
 
+	return FromRequest(r, store)
+}
+
+// FromRequest gets the video a request is for
+func FromRequest(r *VideoRequest, store storage.Backend) (Video, error) {
+	original := source(r, store)
+
+	// Reprocess from the rendition we already have, unless we're forced to start again from the original
+	if r.ProcessedKey != "" && !r.Force {
+		return NewProcessedVideo(r, store, original), nil
+	}
+
+	if original == nil {
+		return nil, errors.New(fmt.Sprintf("VideoRequest %s does not have a valid source", r.Id))
+	}
+
+	return original, nil
+}
+
+// source gets the video from where it came from, or nil if that isn't known
+func source(r *VideoRequest, store storage.Backend) Video {
 	switch r.GetSource() {
 	case SourceS3:
-		return NewS3Video(r, s3, sess, bucket), nil
+		return NewS3Video(r, store)
 	case SourceYoutube:
-		return NewYoutubeVideo(r), nil
+		return NewYoutubeVideo(r)
 	case SourceVimeo:
-		return NewVimeoVideo(r), nil
+		return NewVimeoVideo(r)
 	}
-	return nil, errors.New(fmt.Sprintf("VideoRequest %s does not have a valid source", r.Id))
+
+	return nil
 }
 
-func runCommand(cmd *exec.Cmd) ([]byte, error) {
-	output, err := cmd.CombinedOutput()
+// destination gets where to download a video to. Only the last element of the id is used, so it can't point outside dir
+func destination(dir, id, ext string) string {
+	return filepath.Join(dir, filepath.Base(id)+ext)
+}
 
-	if err != nil {
-		log.Println(cmd.Args)
-		log.Println(err)
-	}
-	return output, err
+// removePartial cleans up whatever a failed or cancelled download left behind at dest
+func removePartial(dest string) {
+	os.Remove(dest)
+	os.Remove(dest + ".part")
 }
@@ -1,12 +1,15 @@
 package video
 
 import (
-	"fmt"
-	"os/exec"
-	"strings"
+	"context"
+	"github.com/therealpenguin/takeabow-upload-processor/command"
+	"github.com/therealpenguin/takeabow-upload-processor/retry"
 )
 
-// VimeoVideo denotes a VideoRequest that you can perform S3 specific things on
+// FormatVimeo is the youtube-dl format we download from Vimeo
+const FormatVimeo = "http-720p/mp4"
+
+// VimeoVideo denotes a VideoRequest that you can perform Vimeo specific things on
 type VimeoVideo struct {
 	*VideoRequest
 }
@@ -15,11 +18,14 @@ func NewVimeoVideo(r *VideoRequest) *VimeoVideo {
 	return &VimeoVideo{r}
 }
 
-func (v *VimeoVideo) HasVideo() (bool, error) {
-	args := strings.Split(fmt.Sprintf("youtube-dl -f http-1080p/http-720p/mp4 -s %s", v.Url), " ")
-	cmd := exec.Command(args[0], args[1:]...)
+func (v *VimeoVideo) HasVideo(ctx context.Context) (bool, error) {
+	cmd, err := command.YoutubeDL(v.Url, "-f", "http-1080p/http-720p/mp4", "-s")
+	// A URL we won't hand to youtube-dl won't get any better by retrying
+	if err != nil {
+		return false, retry.Permanent(err)
+	}
 
-	_, err := runCommand(cmd)
+	_, err = cmd.Run(ctx)
 
 	if err != nil {
 		return false, err
@@ -29,13 +35,17 @@ func (v *VimeoVideo) HasVideo() (bool, error) {
 
 }
 
-func (v *VimeoVideo) GetVideo(dir string) (string, error) {
-	dest := dir + "/" + v.Id + ".mp4"
-	args := strings.Split(fmt.Sprintf("youtube-dl -f http-720p/mp4 -o %s %s", dest, v.Url), " ")
-	cmd := exec.Command(args[0], args[1:]...)
-	_, err := runCommand(cmd)
+func (v *VimeoVideo) GetVideo(ctx context.Context, dir string) (string, error) {
+	dest := destination(dir, v.Id, ".mp4")
+	cmd, err := command.YoutubeDL(v.Url, "-f", FormatVimeo, "-o", dest)
+	if err != nil {
+		return "", retry.Permanent(err)
+	}
+
+	_, err = cmd.Run(ctx)
 
 	if err != nil {
+		removePartial(dest)
 		return "", err
 	}
 
@@ -45,3 +55,8 @@ func (v *VimeoVideo) GetVideo(dir string) (string, error) {
 func (v *VimeoVideo) GetRequest() *VideoRequest {
 	return v.VideoRequest
 }
+
+// Size asks youtube-dl how big the format we download is
+func (v *VimeoVideo) Size(ctx context.Context) (int64, error) {
+	return formatSize(ctx, v.Url, FormatVimeo)
+}
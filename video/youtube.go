@@ -1,11 +1,15 @@
 package video
 
 import (
-	"fmt"
-	"os/exec"
-	"strings"
+	"context"
+	"encoding/json"
+	"github.com/therealpenguin/takeabow-upload-processor/command"
+	"github.com/therealpenguin/takeabow-upload-processor/retry"
 )
 
+// FormatYoutube is the youtube-dl format we download from Youtube
+const FormatYoutube = "137/136/22/mp4"
+
 // YoutubeVideo denotes a VideoRequest that you can perform Youtube specific things on
 type YoutubeVideo struct {
 	*VideoRequest
@@ -15,11 +19,14 @@ func NewYoutubeVideo(r *VideoRequest) *YoutubeVideo {
 	return &YoutubeVideo{r}
 }
 
-func (v *YoutubeVideo) HasVideo() (bool, error) {
-	args := strings.Split(fmt.Sprintf("youtube-dl -f 137/136/22/mp4 -s %s", v.Url), " ")
-	cmd := exec.Command(args[0], args[1:]...)
+func (v *YoutubeVideo) HasVideo(ctx context.Context) (bool, error) {
+	cmd, err := command.YoutubeDL(v.Url, "-f", FormatYoutube, "-s")
+	// A URL we won't hand to youtube-dl won't get any better by retrying
+	if err != nil {
+		return false, retry.Permanent(err)
+	}
 
-	_, err := runCommand(cmd)
+	_, err = cmd.Run(ctx)
 
 	if err != nil {
 		return false, err
@@ -28,13 +35,17 @@ func (v *YoutubeVideo) HasVideo() (bool, error) {
 	return true, err
 }
 
-func (v *YoutubeVideo) GetVideo(dir string) (string, error) {
-	dest := dir + "/" + v.Id + ".mp4"
-	args := strings.Split(fmt.Sprintf("youtube-dl -f youtube-dl -f 137/136/22/mp4 -o %s %s", dest, v.Url), " ")
-	cmd := exec.Command(args[0], args[1:]...)
-	_, err := runCommand(cmd)
+func (v *YoutubeVideo) GetVideo(ctx context.Context, dir string) (string, error) {
+	dest := destination(dir, v.Id, ".mp4")
+	cmd, err := command.YoutubeDL(v.Url, "-f", FormatYoutube, "-o", dest)
+	if err != nil {
+		return "", retry.Permanent(err)
+	}
+
+	_, err = cmd.Run(ctx)
 
 	if err != nil {
+		removePartial(dest)
 		return "", err
 	}
 
@@ -44,3 +55,39 @@ func (v *YoutubeVideo) GetVideo(dir string) (string, error) {
 func (v *YoutubeVideo) GetRequest() *VideoRequest {
 	return v.VideoRequest
 }
+
+// formatInfo is the part of youtube-dl's description of a video that says how big the chosen format is
+type formatInfo struct {
+	Filesize       int64   `json:"filesize"`
+	FilesizeApprox float64 `json:"filesize_approx"`
+}
+
+// formatSize asks youtube-dl how big url is in format, without downloading it. Sites don't always say, which is a size of 0
+func formatSize(ctx context.Context, url, format string) (int64, error) {
+	cmd, err := command.YoutubeDL(url, "-f", format, "-j")
+	if err != nil {
+		return 0, retry.Permanent(err)
+	}
+
+	out, err := cmd.Run(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	info := formatInfo{}
+	err = json.Unmarshal(out, &info)
+	if err != nil {
+		return 0, err
+	}
+
+	if info.Filesize > 0 {
+		return info.Filesize, nil
+	}
+
+	return int64(info.FilesizeApprox), nil
+}
+
+// Size asks youtube-dl how big the format we download is
+func (v *YoutubeVideo) Size(ctx context.Context) (int64, error) {
+	return formatSize(ctx, v.Url, FormatYoutube)
+}
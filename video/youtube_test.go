@@ -0,0 +1,39 @@
+package video
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/therealpenguin/takeabow-upload-processor/retry"
+	"testing"
+)
+
+func TestHostileUrlsAreNotRun(t *testing.T) {
+	urls := []string{
+		"--exec=reboot",
+		"-o/etc/passwd",
+		"file:///etc/passwd",
+	}
+
+	for _, u := range urls {
+		r := &VideoRequest{Id: "foo", Url: u}
+
+		_, err := NewYoutubeVideo(r).HasVideo(context.Background())
+		assert.NotNil(t, err, u)
+		assert.True(t, retry.IsPermanent(err), u)
+
+		_, err = NewYoutubeVideo(r).GetVideo(context.Background(), "/tmp")
+		assert.True(t, retry.IsPermanent(err), u)
+
+		_, err = NewVimeoVideo(r).HasVideo(context.Background())
+		assert.True(t, retry.IsPermanent(err), u)
+
+		_, err = NewVimeoVideo(r).GetVideo(context.Background(), "/tmp")
+		assert.NotNil(t, err, u)
+		assert.True(t, retry.IsPermanent(err), u)
+	}
+}
+
+func TestDestinationStaysInDir(t *testing.T) {
+	assert.Equal(t, "/tmp/foo.mp4", destination("/tmp", "foo", ".mp4"))
+	assert.Equal(t, "/tmp/passwd.mp4", destination("/tmp", "../../etc/passwd", ".mp4"))
+}
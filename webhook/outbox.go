@@ -0,0 +1,233 @@
+package webhook
+
+import (
+	"context"
+	"database/sql"
+	"github.com/therealpenguin/takeabow-upload-processor/logging"
+	"github.com/therealpenguin/takeabow-upload-processor/metrics"
+	"github.com/therealpenguin/takeabow-upload-processor/retry"
+	"log/slog"
+	"time"
+)
+
+// Schema creates the outbox table if it's missing
+const Schema = `CREATE TABLE IF NOT EXISTS webhook_outbox (
+	id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT PRIMARY KEY,
+	video_id VARCHAR(64) NOT NULL,
+	url VARCHAR(2048) NOT NULL,
+	event VARCHAR(64) NOT NULL,
+	body MEDIUMBLOB NOT NULL,
+	attempts INT NOT NULL DEFAULT 0,
+	last_error TEXT NULL,
+	next_attempt_at DATETIME(3) NOT NULL,
+	delivered_at DATETIME(3) NULL,
+	failed_at DATETIME(3) NULL,
+	created_at DATETIME(3) NOT NULL,
+	KEY webhook_outbox_due (delivered_at, failed_at, next_attempt_at)
+)`
+
+// PollInterval is how often the outbox looks for callbacks that are due
+const PollInterval = 5 * time.Second
+
+// MaxAttempts is how many times a callback is tried before it's given up on
+const MaxAttempts = 10
+
+// RetryDelay is the first delay between attempts, doubling up to MaxRetryDelay
+const RetryDelay = 30 * time.Second
+const MaxRetryDelay = 6 * time.Hour
+
+// Lease is how long a callback being sent is hidden from other senders
+const Lease = time.Minute
+
+// BatchSize is the most callbacks sent per poll
+const BatchSize = 100
+
+// Outbox keeps callbacks in MySQL until they're delivered, so they outlive restarts and partners being down
+type Outbox struct {
+	store  store
+	sender *Sender
+}
+
+// NewOutbox delivers callbacks kept in db with sender
+func NewOutbox(db *sql.DB, sender *Sender) *Outbox {
+	return &Outbox{&sqlStore{db}, sender}
+}
+
+// CreateTable makes sure the outbox table exists
+func (o *Outbox) CreateTable(ctx context.Context) error {
+	return o.store.createTable(ctx)
+}
+
+// Enqueue stores a callback to be sent as soon as possible
+func (o *Outbox) Enqueue(ctx context.Context, videoID, url, event string, body []byte) error {
+	err := ValidURL(url)
+	if err != nil {
+		return err
+	}
+
+	return o.store.insert(ctx, delivery{videoID: videoID, url: url, event: event, body: body}, time.Now())
+}
+
+// Run sends callbacks as they fall due until ctx is cancelled
+func (o *Outbox) Run(ctx context.Context) {
+	for {
+		err := o.deliverDue(ctx)
+		if err != nil && ctx.Err() == nil {
+			slog.Error("Error delivering webhooks", logging.Err(err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(PollInterval):
+		}
+	}
+}
+
+// delivery is a callback waiting in the outbox
+type delivery struct {
+	id       int64
+	videoID  string
+	url      string
+	event    string
+	body     []byte
+	attempts int
+}
+
+// deliverDue tries every callback whose next attempt is due
+func (o *Outbox) deliverDue(ctx context.Context) error {
+	due, err := o.store.due(ctx, time.Now(), BatchSize)
+	if err != nil {
+		return err
+	}
+
+	for _, d := range due {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		// Push the next attempt back by Lease, so another sender polling the same table skips it
+		now := time.Now()
+		claimed, err := o.store.claim(ctx, d.id, now, now.Add(Lease))
+		if err != nil {
+			return err
+		}
+		if !claimed {
+			continue
+		}
+
+		err = o.deliver(ctx, d)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// deliver sends a callback and records how it went. The error is only for failing to record it
+func (o *Outbox) deliver(ctx context.Context, d delivery) error {
+	logger := slog.With(logging.KeyVideoID, d.videoID, "delivery", d.id, "event", d.event, logging.KeyAttempt, d.attempts+1)
+
+	err := o.sender.Send(ctx, d.url, d.event, d.id, d.body)
+	if err == nil {
+		metrics.Webhooks.WithLabelValues("delivered").Inc()
+		logger.Info("Delivered webhook")
+		return o.store.delivered(ctx, d.id, time.Now())
+	}
+
+	if retry.IsPermanent(err) || d.attempts+1 >= MaxAttempts {
+		metrics.Webhooks.WithLabelValues("failed").Inc()
+		logger.Error("Giving up on webhook", logging.Err(err))
+		return o.store.failed(ctx, d.id, time.Now(), err.Error())
+	}
+
+	delay := retry.Backoff(d.attempts+1, RetryDelay, MaxRetryDelay)
+	metrics.Webhooks.WithLabelValues("retried").Inc()
+	logger.Warn("Retrying webhook", "delay", delay.String(), logging.Err(err))
+
+	return o.store.retryAt(ctx, d.id, time.Now().Add(delay), err.Error())
+}
+
+// store is where the outbox keeps its callbacks. Every attempt recorded adds one to the callback's attempts
+type store interface {
+	createTable(ctx context.Context) error
+	insert(ctx context.Context, d delivery, at time.Time) error
+
+	// due gets up to limit callbacks that are neither delivered nor given up on, and whose next attempt is at or before at
+	due(ctx context.Context, at time.Time, limit int) ([]delivery, error)
+
+	// claim moves a callback's next attempt to until, if it's still due at at. It reports whether it did
+	claim(ctx context.Context, id int64, at, until time.Time) (bool, error)
+
+	delivered(ctx context.Context, id int64, at time.Time) error
+	failed(ctx context.Context, id int64, at time.Time, reason string) error
+	retryAt(ctx context.Context, id int64, at time.Time, reason string) error
+}
+
+// sqlStore keeps callbacks in the webhook_outbox table
+type sqlStore struct {
+	db *sql.DB
+}
+
+func (s *sqlStore) createTable(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, Schema)
+	return err
+}
+
+func (s *sqlStore) insert(ctx context.Context, d delivery, at time.Time) error {
+	query := `INSERT INTO webhook_outbox (video_id, url, event, body, next_attempt_at, created_at) VALUES (?, ?, ?, ?, ?, ?)`
+	_, err := s.db.ExecContext(ctx, query, d.videoID, d.url, d.event, d.body, at, at)
+	return err
+}
+
+func (s *sqlStore) due(ctx context.Context, at time.Time, limit int) ([]delivery, error) {
+	query := `SELECT id, video_id, url, event, body, attempts FROM webhook_outbox
+		WHERE delivered_at IS NULL AND failed_at IS NULL AND next_attempt_at <= ?
+		ORDER BY id LIMIT ?`
+	rows, err := s.db.QueryContext(ctx, query, at, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	due := make([]delivery, 0)
+	for rows.Next() {
+		var d delivery
+		err = rows.Scan(&d.id, &d.videoID, &d.url, &d.event, &d.body, &d.attempts)
+		if err != nil {
+			return nil, err
+		}
+		due = append(due, d)
+	}
+
+	return due, rows.Err()
+}
+
+func (s *sqlStore) claim(ctx context.Context, id int64, at, until time.Time) (bool, error) {
+	query := `UPDATE webhook_outbox SET next_attempt_at = ? WHERE id = ? AND delivered_at IS NULL AND failed_at IS NULL AND next_attempt_at <= ?`
+	res, err := s.db.ExecContext(ctx, query, until, id, at)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+func (s *sqlStore) delivered(ctx context.Context, id int64, at time.Time) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE webhook_outbox SET delivered_at = ?, attempts = attempts + 1 WHERE id = ?`, at, id)
+	return err
+}
+
+func (s *sqlStore) failed(ctx context.Context, id int64, at time.Time, reason string) error {
+	query := `UPDATE webhook_outbox SET failed_at = ?, attempts = attempts + 1, last_error = ? WHERE id = ?`
+	_, err := s.db.ExecContext(ctx, query, at, reason, id)
+	return err
+}
+
+func (s *sqlStore) retryAt(ctx context.Context, id int64, at time.Time, reason string) error {
+	query := `UPDATE webhook_outbox SET next_attempt_at = ?, attempts = attempts + 1, last_error = ? WHERE id = ?`
+	_, err := s.db.ExecContext(ctx, query, at, reason, id)
+	return err
+}
@@ -0,0 +1,179 @@
+package webhook
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/therealpenguin/takeabow-upload-processor/retry"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// row is a callback in memStore, with the columns of webhook_outbox
+type row struct {
+	delivery
+	next      time.Time
+	delivered bool
+	failed    bool
+	lastError string
+}
+
+// memStore keeps callbacks the way the webhook_outbox table does
+type memStore struct {
+	mu   sync.Mutex
+	rows []*row
+}
+
+func (s *memStore) createTable(ctx context.Context) error {
+	return nil
+}
+
+func (s *memStore) insert(ctx context.Context, d delivery, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	d.id = int64(len(s.rows) + 1)
+	s.rows = append(s.rows, &row{delivery: d, next: at})
+	return nil
+}
+
+func (s *memStore) due(ctx context.Context, at time.Time, limit int) ([]delivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	due := make([]delivery, 0)
+	for _, r := range s.rows {
+		if !r.delivered && !r.failed && !r.next.After(at) && len(due) < limit {
+			due = append(due, r.delivery)
+		}
+	}
+	return due, nil
+}
+
+func (s *memStore) claim(ctx context.Context, id int64, at, until time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r := s.rows[id-1]
+	if r.delivered || r.failed || r.next.After(at) {
+		return false, nil
+	}
+	r.next = until
+	return true, nil
+}
+
+func (s *memStore) delivered(ctx context.Context, id int64, at time.Time) error {
+	return s.update(id, func(r *row) { r.delivered = true })
+}
+
+func (s *memStore) failed(ctx context.Context, id int64, at time.Time, reason string) error {
+	return s.update(id, func(r *row) { r.failed, r.lastError = true, reason })
+}
+
+func (s *memStore) retryAt(ctx context.Context, id int64, at time.Time, reason string) error {
+	return s.update(id, func(r *row) { r.next, r.lastError = at, reason })
+}
+
+func (s *memStore) update(id int64, f func(r *row)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r := s.rows[id-1]
+	r.attempts++
+	f(r)
+	return nil
+}
+
+// newTestOutbox gets an outbox in memory, and a partner that answers with whatever status is set to
+func newTestOutbox(t *testing.T, status *int) (*Outbox, *memStore, *int) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(*status)
+	}))
+	t.Cleanup(server.Close)
+
+	store := &memStore{}
+	o := &Outbox{store: store, sender: newTestSender()}
+	assert.NoError(t, o.Enqueue(context.Background(), "abc", server.URL, "video.transcoded", []byte("{}")))
+
+	return o, store, &calls
+}
+
+func TestOutboxDelivers(t *testing.T) {
+	status := http.StatusOK
+	o, store, calls := newTestOutbox(t, &status)
+
+	assert.NoError(t, o.deliverDue(context.Background()))
+	assert.True(t, store.rows[0].delivered)
+	assert.Equal(t, 1, store.rows[0].attempts)
+
+	// Nothing is sent again once it's delivered
+	assert.NoError(t, o.deliverDue(context.Background()))
+	assert.Equal(t, 1, *calls)
+}
+
+func TestOutboxBacksOff(t *testing.T) {
+	status := http.StatusServiceUnavailable
+	o, store, calls := newTestOutbox(t, &status)
+
+	before := time.Now()
+	assert.NoError(t, o.deliverDue(context.Background()))
+
+	r := store.rows[0]
+	assert.False(t, r.delivered || r.failed)
+	assert.Equal(t, 1, r.attempts)
+	assert.Contains(t, r.lastError, "503")
+	assert.False(t, r.next.Before(before.Add(retry.Backoff(1, RetryDelay, MaxRetryDelay))))
+
+	// It isn't tried again until the delay is over
+	assert.NoError(t, o.deliverDue(context.Background()))
+	assert.Equal(t, 1, *calls)
+
+	r.next = time.Now()
+	r.attempts = MaxAttempts - 1
+	assert.NoError(t, o.deliverDue(context.Background()))
+	assert.True(t, r.failed)
+	assert.Equal(t, MaxAttempts, r.attempts)
+}
+
+func TestOutboxGivesUpOnPermanentErrors(t *testing.T) {
+	status := http.StatusNotFound
+	o, store, _ := newTestOutbox(t, &status)
+
+	assert.NoError(t, o.deliverDue(context.Background()))
+	assert.True(t, store.rows[0].failed)
+	assert.Equal(t, 1, store.rows[0].attempts)
+}
+
+// staleStore finds the callbacks that were due when another sender looked
+type staleStore struct {
+	*memStore
+	stale []delivery
+}
+
+func (s *staleStore) due(ctx context.Context, at time.Time, limit int) ([]delivery, error) {
+	return s.stale, nil
+}
+
+func TestOutboxClaimsBeforeSending(t *testing.T) {
+	status := http.StatusOK
+	o, store, calls := newTestOutbox(t, &status)
+
+	// Another sender claims the callback after this one found it due
+	due, _ := store.due(context.Background(), time.Now(), BatchSize)
+	claimed, _ := store.claim(context.Background(), due[0].id, time.Now(), time.Now().Add(Lease))
+	assert.True(t, claimed)
+
+	o.store = &staleStore{store, due}
+	assert.NoError(t, o.deliverDue(context.Background()))
+	assert.Equal(t, 0, *calls)
+	assert.False(t, store.rows[0].delivered)
+}
+
+func TestOutboxRejectsBadURLs(t *testing.T) {
+	o := &Outbox{store: &memStore{}, sender: newTestSender()}
+	assert.Error(t, o.Enqueue(context.Background(), "abc", "/hooks/bow", "video.transcoded", []byte("{}")))
+}
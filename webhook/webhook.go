@@ -0,0 +1,167 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/therealpenguin/takeabow-upload-processor/retry"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+const HeaderSignature = "X-Bow-Signature"
+const HeaderTimestamp = "X-Bow-Timestamp"
+const HeaderEvent = "X-Bow-Event"
+const HeaderDelivery = "X-Bow-Delivery"
+
+// SendTimeout is how long a partner has to answer a callback
+const SendTimeout = 15 * time.Second
+
+// Tolerance is how far a callback's timestamp can be from now for Verify to accept it, so old callbacks can't be replayed
+const Tolerance = 5 * time.Minute
+
+// ErrForbiddenAddress means a callback URL resolved to an address that isn't on the public internet
+var ErrForbiddenAddress = errors.New("Callbacks can't be sent to a loopback, private, link-local or unspecified address")
+
+// Sign gets the signature of a callback, which partners check by computing the same HMAC-SHA256 of timestamp, a dot and body with their secret
+func Sign(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify reports whether signature is timestamp and body signed with secret, and timestamp is within Tolerance of now
+func Verify(secret []byte, timestamp string, body []byte, signature string) bool {
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+
+	age := time.Since(time.Unix(unix, 0))
+	if age > Tolerance || age < -Tolerance {
+		return false
+	}
+
+	return hmac.Equal([]byte(Sign(secret, timestamp, body)), []byte(signature))
+}
+
+// ValidURL checks a callback URL is absolute and http or https
+func ValidURL(callback string) error {
+	u, err := url.Parse(callback)
+	if err != nil {
+		return err
+	}
+
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("Callback URL %s isn't an absolute http(s) URL", callback)
+	}
+
+	return nil
+}
+
+// Sender POSTs signed callbacks. It won't connect to addresses that aren't on the public internet, or follow redirects
+type Sender struct {
+	client *http.Client
+	secret []byte
+
+	// allowPrivate lets tests send to servers on loopback
+	allowPrivate bool
+}
+
+// NewSender signs callbacks with secret
+func NewSender(secret string) *Sender {
+	s := &Sender{secret: []byte(secret)}
+
+	// Check the address actually dialled, after the name is resolved, so a name can't resolve to a public address once and a private one after
+	dialer := &net.Dialer{
+		Timeout: SendTimeout,
+		Control: func(network, address string, c syscall.RawConn) error {
+			if s.allowPrivate {
+				return nil
+			}
+			return checkAddress(address)
+		},
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// Through a proxy, it would be the proxy's address that's checked rather than the callback's
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+
+	s.client = &http.Client{
+		Timeout:   SendTimeout,
+		Transport: transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	return s
+}
+
+// checkAddress checks a host:port being dialled is a public address
+func checkAddress(address string) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return fmt.Errorf("Can't parse the address %s", host)
+	}
+
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsUnspecified() {
+		return fmt.Errorf("%w: %s", ErrForbiddenAddress, ip)
+	}
+
+	return nil
+}
+
+// Send POSTs body to callback. Answers that retrying won't change, like a 404 or a redirect, and callbacks to forbidden
+// addresses are Permanent errors
+func (s *Sender) Send(ctx context.Context, callback, event string, id int64, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, callback, bytes.NewReader(body))
+	if err != nil {
+		return retry.Permanent(err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "takeabow-upload-processor")
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set(HeaderTimestamp, timestamp)
+	req.Header.Set(HeaderSignature, Sign(s.secret, timestamp, body))
+	req.Header.Set(HeaderEvent, event)
+	req.Header.Set(HeaderDelivery, strconv.FormatInt(id, 10))
+
+	res, err := s.client.Do(req)
+	if errors.Is(err, ErrForbiddenAddress) {
+		return retry.Permanent(err)
+	}
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(res.Body, 64<<10))
+
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		return nil
+	}
+
+	err = fmt.Errorf("Callback %s answered %s", callback, res.Status)
+	if res.StatusCode >= 300 && res.StatusCode < 500 && res.StatusCode != http.StatusRequestTimeout && res.StatusCode != http.StatusTooManyRequests {
+		return retry.Permanent(err)
+	}
+
+	return err
+}
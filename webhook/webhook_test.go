@@ -0,0 +1,122 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/therealpenguin/takeabow-upload-processor/retry"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// newTestSender can send to the test servers, which listen on loopback
+func newTestSender() *Sender {
+	s := NewSender("secret")
+	s.allowPrivate = true
+	return s
+}
+
+func TestSendSignsBody(t *testing.T) {
+	var body []byte
+	var header http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = io.ReadAll(r.Body)
+		header = r.Header
+	}))
+	defer server.Close()
+
+	s := newTestSender()
+	err := s.Send(context.Background(), server.URL, "video.transcoded", 7, []byte(`{"id":"abc"}`))
+
+	assert.NoError(t, err)
+	assert.Equal(t, `{"id":"abc"}`, string(body))
+	assert.Equal(t, "video.transcoded", header.Get(HeaderEvent))
+	assert.Equal(t, "7", header.Get(HeaderDelivery))
+
+	timestamp := header.Get(HeaderTimestamp)
+	assert.True(t, Verify([]byte("secret"), timestamp, body, header.Get(HeaderSignature)))
+	assert.False(t, Verify([]byte("other"), timestamp, body, header.Get(HeaderSignature)))
+}
+
+func TestVerifyRejectsOldTimestamps(t *testing.T) {
+	body := []byte(`{"id":"abc"}`)
+
+	old := strconv.FormatInt(time.Now().Add(-Tolerance-time.Minute).Unix(), 10)
+	assert.False(t, Verify([]byte("secret"), old, body, Sign([]byte("secret"), old, body)))
+
+	// The timestamp is part of what's signed, so it can't be swapped for a fresh one
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	assert.False(t, Verify([]byte("secret"), now, body, Sign([]byte("secret"), old, body)))
+	assert.True(t, Verify([]byte("secret"), now, body, Sign([]byte("secret"), now, body)))
+}
+
+func TestSendRetriesOnlyWhatMightChange(t *testing.T) {
+	status := http.StatusServiceUnavailable
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	s := newTestSender()
+
+	err := s.Send(context.Background(), server.URL, "video.failed", 1, []byte("{}"))
+	assert.Error(t, err)
+	assert.False(t, retry.IsPermanent(err))
+
+	status = http.StatusTooManyRequests
+	err = s.Send(context.Background(), server.URL, "video.failed", 1, []byte("{}"))
+	assert.False(t, retry.IsPermanent(err))
+
+	status = http.StatusGone
+	err = s.Send(context.Background(), server.URL, "video.failed", 1, []byte("{}"))
+	assert.True(t, retry.IsPermanent(err))
+}
+
+func TestSendDoesNotFollowRedirects(t *testing.T) {
+	followed := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/internal" {
+			followed = true
+			return
+		}
+		http.Redirect(w, r, "/internal", http.StatusFound)
+	}))
+	defer server.Close()
+
+	err := newTestSender().Send(context.Background(), server.URL, "video.failed", 1, []byte("{}"))
+	assert.True(t, retry.IsPermanent(err))
+	assert.False(t, followed)
+}
+
+func TestSendRefusesPrivateAddresses(t *testing.T) {
+	called := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+	defer server.Close()
+
+	err := NewSender("secret").Send(context.Background(), server.URL, "video.failed", 1, []byte("{}"))
+	assert.True(t, errors.Is(err, ErrForbiddenAddress))
+	assert.True(t, retry.IsPermanent(err))
+	assert.False(t, called)
+}
+
+func TestCheckAddress(t *testing.T) {
+	forbidden := []string{"127.0.0.1:80", "10.0.0.1:443", "192.168.1.1:80", "172.16.0.1:80", "169.254.169.254:80", "0.0.0.0:80", "[::1]:80", "[fe80::1]:80", "[fd00::1]:80"}
+	for _, address := range forbidden {
+		assert.True(t, errors.Is(checkAddress(address), ErrForbiddenAddress), address)
+	}
+
+	assert.NoError(t, checkAddress("93.184.216.34:443"))
+	assert.NoError(t, checkAddress("[2606:2800:220:1:248:1893:25c8:1946]:443"))
+}
+
+func TestValidURL(t *testing.T) {
+	assert.NoError(t, ValidURL("https://partner.example.com/hooks/bow"))
+	assert.Error(t, ValidURL("/hooks/bow"))
+	assert.Error(t, ValidURL("ftp://partner.example.com/hooks"))
+}